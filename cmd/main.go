@@ -1,7 +1,27 @@
 package main
 
-import "advertise_service/internal"
+import (
+	"advertise_service/internal"
+	"fmt"
+	"os"
+	// schedules are evaluated in IANA timezones, and the runtime image has no tz database
+	_ "time/tzdata"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := internal.MigrateCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := internal.ImportCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	internal.ProductionServerUp()
 }
@@ -1,36 +1,61 @@
 package internal
 
 import (
+	"advertise_service/internal/events"
 	"advertise_service/internal/handlers"
 	"advertise_service/internal/infra"
 	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
 	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/infra/tracing"
+	"advertise_service/internal/ranking"
+	"advertise_service/internal/scheduler"
+	"advertise_service/internal/signer"
+	"advertise_service/internal/targeting"
 	"context"
 	"go.uber.org/zap"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Server struct {
-	mux *http.ServeMux
+	mux       *http.ServeMux
+	recorder  *events.Recorder
+	refresher scheduler.CacheRefresher
 }
 
-func NewServer(storage persistent.Storage, cache cache.Service, logger *zap.Logger) Server {
+func NewServer(storage persistent.Storage, cache cache.Service, ranker ranking.Ranker, links handlers.ClickLinks, logger *zap.Logger) Server {
 	mux := http.NewServeMux()
 
 	loggerMiddleware := logging.LoggerMiddleware{Logger: logger}
+	engine := targeting.NewEngine()
+	recorder := events.NewRecorder(storage, logger)
 	resourceMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, handlers.StorageContextKey{}, storage)
 			ctx = context.WithValue(ctx, handlers.CacheContextKey{}, cache)
+			ctx = context.WithValue(ctx, handlers.TargetingContextKey{}, engine)
+			ctx = context.WithValue(ctx, handlers.RankingContextKey{}, ranker)
+			ctx = context.WithValue(ctx, handlers.ClickLinksContextKey{}, links)
+			ctx = context.WithValue(ctx, handlers.RecorderContextKey{}, recorder)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 
+	//every api route is measured, traced, logged and gets the shared resources in its context
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, metrics.Middleware(pattern, tracing.Middleware(pattern, loggerMiddleware.Middleware(resourceMiddleware(handler)))))
+	}
+
 	//registering handlers
-	mux.Handle("/api/v1/ad", loggerMiddleware.Middleware(resourceMiddleware(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	handle("/api/v1/ad", func(writer http.ResponseWriter, request *http.Request) {
 		switch request.Method {
 		case http.MethodPost:
 			handlers.PostAdHandler(writer, request)
@@ -40,43 +65,286 @@ func NewServer(storage persistent.Storage, cache cache.Service, logger *zap.Logg
 		default:
 			http.NotFound(writer, request)
 		}
-	}))))
+	})
+
+	handle("/api/v1/admin/ad", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.SearchAdsHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/admin/ad/import", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.ImportAdsHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/ad/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.GetAdHandler(writer, request)
+		case http.MethodPatch:
+			handlers.PatchAdHandler(writer, request)
+		case http.MethodDelete:
+			handlers.DeleteAdHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/ad/{id}/pause", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.PauseAdHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
 
-	return Server{mux: mux}
+	handle("/api/v1/ad/{id}/resume", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.ResumeAdHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/ad/{id}/archive", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.ArchiveAdHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/ad/{id}/impression", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.ImpressionHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle(handlers.ClickPathPrefix+"{token}", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.ClickRedirectHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/ad/{id}/report", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.ReportHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/advertiser", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.PostAdvertiserHandler(writer, request)
+		case http.MethodGet:
+			handlers.ListAdvertisersHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/advertiser/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.GetAdvertiserHandler(writer, request)
+		case http.MethodPatch:
+			handlers.PatchAdvertiserHandler(writer, request)
+		case http.MethodDelete:
+			handlers.DeleteAdvertiserHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/campaign", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.PostCampaignHandler(writer, request)
+		case http.MethodGet:
+			handlers.ListCampaignsHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/campaign/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.GetCampaignHandler(writer, request)
+		case http.MethodPatch:
+			handlers.PatchCampaignHandler(writer, request)
+		case http.MethodDelete:
+			handlers.DeleteCampaignHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/segment", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			handlers.PostSegmentHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	handle("/api/v1/segment/{id}", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			handlers.GetSegmentHandler(writer, request)
+		case http.MethodPut:
+			handlers.PutSegmentHandler(writer, request)
+		case http.MethodDelete:
+			handlers.DeleteSegmentHandler(writer, request)
+		default:
+			http.NotFound(writer, request)
+		}
+	})
+
+	mux.Handle("/metrics", metrics.Handler())
+
+	//probes are neither logged nor measured, the load balancer calls them every few seconds
+	mux.Handle("GET /healthz", http.HandlerFunc(handlers.HealthzHandler))
+	mux.Handle("GET /readyz", resourceMiddleware(http.HandlerFunc(handlers.ReadyzHandler)))
+
+	return Server{
+		mux:       mux,
+		recorder:  recorder,
+		refresher: scheduler.NewCacheRefresher(storage, cache, logger),
+	}
 }
 
 func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func newProductionServer(config infra.Config) Server {
+// RunBackground runs the cache refresher and the event recorder until ctx is done
+func (s Server) RunBackground(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.refresher.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		s.recorder.Run(ctx)
+	}()
+	wg.Wait()
+}
+
+const (
+	// ReadHeaderTimeout and ReadTimeout bound how long a client may take to send a request, so slow clients can't pin connections
+	ReadHeaderTimeout = 5 * time.Second
+	ReadTimeout       = 10 * time.Second
+	WriteTimeout      = 15 * time.Second
+	IdleTimeout       = 60 * time.Second
+	// ShutdownTimeout is how long in-flight requests may take to finish after SIGTERM
+	ShutdownTimeout = 20 * time.Second
+)
+
+func ProductionServerUp() {
+	log.Print("Starting advertise service")
+
 	//initializing resources
-	storage, cache := infra.ProductionSetup(config)
+	config := infra.LoadConfig()
+	shutdownTracing, err := tracing.Setup(context.Background(), config.TraceExporter)
+	if err != nil {
+		panic(err)
+	}
+	ranker, err := ranking.NewRanker(config.Ranking)
+	if err != nil {
+		panic(err)
+	}
+	links := handlers.ClickLinks{
+		Signer:  signer.New([]byte(config.ClickSigningKey), signer.DefaultTTL),
+		BaseURL: config.ClickBaseURL,
+	}
+	storage, cache, closeConnections := infra.ProductionSetup(config)
 	logger, err := zap.NewProduction()
 	if err != nil {
 		panic(err)
 	}
+	defer logger.Sync()
 
 	//initializing server
-	return NewServer(storage, cache, logger)
-}
+	mux := NewServer(storage, cache, ranker, links, logger)
 
-func ProductionServerUp() {
-	log.Print("Starting advertise service")
-
-	mux := newProductionServer(infra.LoadConfig())
-
-	var port = "8080"
+	//populate the cache before accepting requests, then keep it fresh in the background
+	_, err = mux.refresher.Refresh(context.Background())
+	if err != nil {
+		log.Printf("Initial cache refresh failed: %v", err)
+	}
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	backgroundDone := make(chan struct{})
+	go func() {
+		mux.RunBackground(backgroundCtx)
+		close(backgroundDone)
+	}()
 
-	done := make(chan bool)
+	server := &http.Server{
+		Addr:              config.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+	}
+	serverErr := make(chan error, 1)
 	go func() {
-		err := http.ListenAndServe(":"+port, mux)
-		if err != nil {
-			log.Fatalf("Server failed to start: %v", err)
-		} else {
-			done <- true
-		}
+		serverErr <- server.ListenAndServe()
 	}()
-	log.Printf("Server started at port %v", port)
-	<-done
+	log.Printf("Server started at %v", config.Address)
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+	failed := false
+	select {
+	case err := <-serverErr:
+		failed = true
+		log.Printf("Server failed: %v", err)
+	case <-signalCtx.Done():
+		log.Print("Shutting down, draining requests")
+	}
+
+	//stop accepting requests and wait for the in-flight ones, then stop the background work which flushes the recorded events,
+	//the connections are closed last since both of them still use them
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	stopBackground()
+	<-backgroundDone
+	if err := closeConnections(); err != nil {
+		log.Printf("Closing connections failed: %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Flushing traces failed: %v", err)
+	}
+	log.Print("Server stopped")
+	if failed {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+var (
+	// FlushPeriod is how often buffered events are written to the storage
+	FlushPeriod = 5 * time.Second
+
+	// BatchSize triggers an early flush once this many events are buffered
+	BatchSize = 1000
+
+	// MaxBuffered is the amount of events kept in memory while the storage is unavailable, newer events are dropped
+	MaxBuffered = 100_000
+
+	// MaxFlushAttempts is how many times a batch is written before it's dropped,
+	// so a batch the storage always rejects doesn't block the newer events
+	MaxFlushAttempts = 5
+)
+
+// Recorder buffers events in memory and writes them to the storage in batches,
+// so recording an event never waits for the database.
+type Recorder struct {
+	storage persistent.Storage
+	logger  *zap.Logger
+
+	mu     sync.Mutex
+	buffer []models.Event
+	// failed is the batch of the last failed flush, it's retried before the buffer
+	failed   []models.Event
+	attempts int
+	// full is signaled when the buffer reaches BatchSize
+	full chan struct{}
+}
+
+func NewRecorder(storage persistent.Storage, logger *zap.Logger) *Recorder {
+	return &Recorder{
+		storage: storage,
+		logger:  logger,
+		full:    make(chan struct{}, 1),
+	}
+}
+
+// Record adds the event to the buffer
+func (r *Recorder) Record(event models.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buffer)+len(r.failed) >= MaxBuffered {
+		r.logger.Log(zap.WarnLevel, "event buffer is full, dropping event", zap.String("ad_id", event.AdID.String()), zap.String("kind", string(event.Kind)))
+		return
+	}
+	r.buffer = append(r.buffer, event)
+	if len(r.buffer) >= BatchSize {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes the buffered events to the storage.
+// A failed batch is retried on the next flushes before any newer events, and dropped after MaxFlushAttempts.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	events, attempts := r.failed, r.attempts
+	if len(events) == 0 {
+		events, attempts = r.buffer, 0
+		r.buffer = nil
+	}
+	r.failed, r.attempts = nil, 0
+	r.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+
+	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, r.logger)
+	err := r.storage.InsertEvents(ctx, events)
+	if err != nil {
+		attempts++
+		if attempts >= MaxFlushAttempts {
+			r.logger.Log(zap.ErrorLevel, "dropping events that failed to flush", zap.Int("amount", len(events)), zap.Int("attempts", attempts))
+			return err
+		}
+		r.mu.Lock()
+		r.failed, r.attempts = events, attempts
+		r.mu.Unlock()
+		return err
+	}
+	r.logger.Log(zap.DebugLevel, "flushed events", zap.Int("amount", len(events)))
+	return nil
+}
+
+// Run flushes the buffer every FlushPeriod or when it's full, until ctx is done.
+// The remaining events are flushed before returning.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(FlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			//ctx is already done, so the last flush needs its own deadline
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := r.Flush(flushCtx); err != nil {
+				r.logger.Log(zap.ErrorLevel, "error flushing events on shutdown", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+		case <-r.full:
+		}
+		if err := r.Flush(ctx); err != nil {
+			r.logger.Log(zap.ErrorLevel, "error flushing events", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"advertise_service/internal/models"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
+	storage := mock.NewStorage()
+	recorder := NewRecorder(storage, logger)
+
+	adID := uuid.New()
+	now := time.Now().UTC()
+	params := models.ConditionParams{Age: 30, Gender: models.Male, Country: models.Japan, Platform: models.Web}
+	for range 5 {
+		recorder.Record(models.Event{ID: uuid.New(), AdID: adID, Kind: models.Impression, OccurredAt: now, Params: params})
+	}
+	recorder.Record(models.Event{ID: uuid.New(), AdID: adID, Kind: models.Click, OccurredAt: now, Params: params})
+
+	//nothing is written before flushing
+	report, err := storage.ReportEvents(ctx, adID, now, now)
+	require.NoError(t, err)
+	assert.Empty(t, report)
+
+	require.NoError(t, recorder.Flush(ctx))
+	report, err = storage.ReportEvents(ctx, adID, now, now)
+	require.NoError(t, err)
+	require.Len(t, report, 1)
+	assert.Equal(t, int64(5), report[0].Impressions)
+	assert.Equal(t, int64(1), report[0].Clicks)
+
+	//flushing an empty buffer is a no-op
+	require.NoError(t, recorder.Flush(ctx))
+}
+
+func TestRecorderFlushesOnShutdown(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
+	storage := mock.NewStorage()
+	recorder := NewRecorder(storage, logger)
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recorder.Run(runCtx)
+		close(done)
+	}()
+
+	adID := uuid.New()
+	now := time.Now().UTC()
+	recorder.Record(models.Event{ID: uuid.New(), AdID: adID, Kind: models.Impression, OccurredAt: now, Params: models.ConditionParams{Gender: models.Male, Country: models.Japan, Platform: models.Web}})
+	cancel()
+	<-done
+
+	report, err := storage.ReportEvents(ctx, adID, now, now)
+	require.NoError(t, err)
+	require.Len(t, report, 1)
+	assert.Equal(t, int64(1), report[0].Impressions)
+}
+
+// rejectingStorage fails to insert any event of the rejected ad
+type rejectingStorage struct {
+	persistent.Storage
+	rejected uuid.UUID
+	attempts int
+}
+
+func (s *rejectingStorage) InsertEvents(ctx context.Context, events []models.Event) error {
+	for _, event := range events {
+		if event.AdID == s.rejected {
+			s.attempts++
+			return errors.New("constraint violation")
+		}
+	}
+	return s.Storage.InsertEvents(ctx, events)
+}
+
+func TestRecorderDropsRejectedBatch(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
+	storage := &rejectingStorage{Storage: mock.NewStorage(), rejected: uuid.New()}
+	recorder := NewRecorder(storage, logger)
+
+	now := time.Now().UTC()
+	params := models.ConditionParams{Gender: models.Male, Country: models.Japan, Platform: models.Web}
+	recorder.Record(models.Event{ID: uuid.New(), AdID: storage.rejected, Kind: models.Impression, OccurredAt: now, Params: params})
+	for range MaxFlushAttempts {
+		require.Error(t, recorder.Flush(ctx))
+	}
+	assert.Equal(t, MaxFlushAttempts, storage.attempts)
+
+	//newer events are written once the rejected batch is dropped
+	adID := uuid.New()
+	recorder.Record(models.Event{ID: uuid.New(), AdID: adID, Kind: models.Impression, OccurredAt: now, Params: params})
+	require.NoError(t, recorder.Flush(ctx))
+	assert.Equal(t, MaxFlushAttempts, storage.attempts)
+	report, err := storage.ReportEvents(ctx, adID, now, now)
+	require.NoError(t, err)
+	require.Len(t, report, 1)
+}
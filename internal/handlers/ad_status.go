@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+var (
+	errAdArchived = errors.New("ad is archived")
+	errAdEnded    = errors.New("ad has already ended")
+)
+
+func PauseAdHandler(writer http.ResponseWriter, request *http.Request) {
+	adStatusHandler(writer, request, pauseAd)
+}
+
+func ResumeAdHandler(writer http.ResponseWriter, request *http.Request) {
+	adStatusHandler(writer, request, resumeAd)
+}
+
+func ArchiveAdHandler(writer http.ResponseWriter, request *http.Request) {
+	adStatusHandler(writer, request, archiveAd)
+}
+
+func adStatusHandler(writer http.ResponseWriter, request *http.Request, action func(ctx context.Context, id uuid.UUID) (models.Ad, error)) {
+	logger := request.Context().Value(logging.LoggerContextKey{}).(*zap.Logger)
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ad, err := action(request.Context(), id)
+	switch {
+	case errors.Is(err, persistent.ErrAdNotFound):
+		http.NotFound(writer, request)
+		return
+	case errors.Is(err, errAdArchived), errors.Is(err, errAdEnded):
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(ad)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error encoding response", zap.Error(err))
+		http.Error(writer, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+// pauseAd stops serving the ad until it's resumed
+func pauseAd(ctx context.Context, id uuid.UUID) (models.Ad, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	ad, err := database.GetAd(ctx, id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	if ad.Status == models.StatusArchived {
+		return models.Ad{}, errAdArchived
+	}
+
+	err = database.SetAdStatus(ctx, id, models.StatusPaused)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	ad.Status = models.StatusPaused
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = cacheService.RemoveActiveAd(ctx, id)
+	if err != nil {
+		// the paused ad will be dropped on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error removing paused ad from cache", zap.Error(err))
+	}
+	return ad, nil
+}
+
+// resumeAd serves a paused ad again, within its original start and end time
+func resumeAd(ctx context.Context, id uuid.UUID) (models.Ad, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	ad, err := database.GetAd(ctx, id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	if ad.Status == models.StatusArchived {
+		return models.Ad{}, errAdArchived
+	}
+	if !ad.EndAt.After(time.Now().UTC()) {
+		return models.Ad{}, errAdEnded
+	}
+
+	err = database.SetAdStatus(ctx, id, models.StatusActive)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	ad.Status = models.StatusActive
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = syncCachedAd(ctx, cacheService, ad)
+	if err != nil {
+		// the resumed ad will be picked up on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error caching resumed ad", zap.Error(err))
+	}
+	return ad, nil
+}
+
+// archiveAd takes the ad down for good, an archived ad can't be paused or resumed anymore
+func archiveAd(ctx context.Context, id uuid.UUID) (models.Ad, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	ad, err := database.GetAd(ctx, id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	if ad.Status == models.StatusArchived {
+		return ad, nil
+	}
+
+	err = database.SetAdStatus(ctx, id, models.StatusArchived)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	ad.Status = models.StatusArchived
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = cacheService.RemoveActiveAd(ctx, id)
+	if err != nil {
+		// the archived ad will be dropped on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error removing archived ad from cache", zap.Error(err))
+	}
+	return ad, nil
+}
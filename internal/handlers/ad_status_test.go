@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestPauseAndResumeAd(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	testAd := mock.GenerateMockAds()[1]
+	created, err := postAd(ctx, PostAdRequest{
+		Title:   testAd.Title,
+		StartAt: testAd.StartAt,
+		EndAt:   testAd.EndAt,
+	})
+	require.NoError(t, err)
+	id := uuid.MustParse(created.AdID)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	ad, err := pauseAd(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusPaused, ad.Status)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+
+	//editing a paused ad doesn't serve it again
+	title := "paused and edited"
+	_, err = patchAd(ctx, id, PatchAdRequest{Title: &title})
+	require.NoError(t, err)
+	cached, err = cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+
+	ad, err = resumeAd(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusActive, ad.Status)
+	cached, err = cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, id, cached[0].ID)
+
+	ad, err = archiveAd(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusArchived, ad.Status)
+	cached, err = cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+	stored, err := storage.GetAd(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusArchived, stored.Status)
+	//archiving is idempotent, and archived ads can't be served again
+	_, err = archiveAd(ctx, id)
+	require.NoError(t, err)
+	_, err = resumeAd(ctx, id)
+	assert.ErrorIs(t, err, errAdArchived)
+	_, err = pauseAd(ctx, id)
+	assert.ErrorIs(t, err, errAdArchived)
+
+	_, err = pauseAd(ctx, uuid.New())
+	assert.ErrorIs(t, err, persistent.ErrAdNotFound)
+	_, err = archiveAd(ctx, uuid.New())
+	assert.ErrorIs(t, err, persistent.ErrAdNotFound)
+}
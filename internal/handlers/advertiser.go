@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const MaxNameLength = 100
+
+type AdvertiserRequest struct {
+	Name string `json:"name"`
+}
+
+func PostAdvertiserHandler(writer http.ResponseWriter, request *http.Request) {
+	reqBody := AdvertiserRequest{}
+	err := json.NewDecoder(request.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = validateName(reqBody.Name)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	advertiser, err := postAdvertiser(request.Context(), reqBody)
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusCreated, advertiser)
+}
+
+func ListAdvertisersHandler(writer http.ResponseWriter, request *http.Request) {
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	advertisers, err := database.ListAdvertisers(request.Context())
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, advertisers)
+}
+
+func GetAdvertiserHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "advertiser")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	advertiser, err := database.GetAdvertiser(request.Context(), id)
+	if errors.Is(err, persistent.ErrAdvertiserNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, advertiser)
+}
+
+// PatchAdvertiserHandler renames an advertiser, the name is the only field of an advertiser
+func PatchAdvertiserHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "advertiser")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reqBody := AdvertiserRequest{}
+	err = json.NewDecoder(request.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = validateName(reqBody.Name)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	advertiser := models.Advertiser{ID: id, Name: reqBody.Name}
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	err = database.UpdateAdvertiser(request.Context(), advertiser)
+	if errors.Is(err, persistent.ErrAdvertiserNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, advertiser)
+}
+
+func DeleteAdvertiserHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "advertiser")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	err = database.DeleteAdvertiser(request.Context(), id)
+	switch {
+	case errors.Is(err, persistent.ErrAdvertiserNotFound):
+		http.NotFound(writer, request)
+		return
+	case errors.Is(err, persistent.ErrAdvertiserHasCampaigns):
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func postAdvertiser(ctx context.Context, reqBody AdvertiserRequest) (models.Advertiser, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	advertiser := models.Advertiser{ID: uuid.New(), Name: reqBody.Name}
+	err := database.InsertAdvertiser(ctx, advertiser)
+	if err != nil {
+		return models.Advertiser{}, err
+	}
+	return advertiser, nil
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if len(name) > MaxNameLength {
+		return errors.New("name too long")
+	}
+	return nil
+}
+
+// writeResource encodes a resource as the json response
+func writeResource(writer http.ResponseWriter, request *http.Request, status int, resource any) {
+	logger := request.Context().Value(logging.LoggerContextKey{}).(*zap.Logger)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(resource)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error encoding response", zap.Error(err))
+	}
+}
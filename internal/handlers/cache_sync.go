@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+// shouldCache reports if the ad belongs to the cached active set, that is it starts within the next cache window and hasn't ended.
+func shouldCache(ad models.Ad) bool {
+	now := time.Now().UTC()
+	return ad.StartAt.Before(now.Add(cache.Interval+cache.Tolerance)) && ad.EndAt.After(now)
+}
+
+// syncCachedAd replaces the cached entry of the ad, so a modified ad is served right away instead of after the next cache refresh.
+func syncCachedAd(ctx context.Context, cacheService cache.Service, ad models.Ad) error {
+	err := cacheService.RemoveActiveAd(ctx, ad.ID)
+	if err != nil {
+		return err
+	}
+	return cacheAd(ctx, cacheService, ad)
+}
+
+// cacheAd writes the ad to the cache if it belongs to the cached active set, narrowed to the window of its campaign
+// the same way persistent.Storage.FindAdsWithTime does for the cache refresh.
+func cacheAd(ctx context.Context, cacheService cache.Service, ad models.Ad) error {
+	if !ad.IsEnabled() {
+		return nil
+	}
+	if ad.CampaignID != nil {
+		database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+		campaign, err := database.GetCampaign(ctx, *ad.CampaignID)
+		if err != nil {
+			return err
+		}
+		var served bool
+		ad, served = campaign.Constrain(ad)
+		if !served {
+			return nil
+		}
+	}
+	if !shouldCache(ad) {
+		return nil
+	}
+	return cacheService.WriteActiveAd(ctx, ad)
+}
+
+// cacheAds writes the ads that belong to the cached active set in one batch, ads must already be narrowed to their campaigns.
+// The cache isn't marked as refreshed, so the scheduler.CacheRefresher still reloads every active ad when it's due.
+func cacheAds(ctx context.Context, cacheService cache.Service, ads []models.Ad) error {
+	ads = slices.DeleteFunc(ads, func(ad models.Ad) bool {
+		return !shouldCache(ad)
+	})
+	return cacheService.WriteActiveAds(ctx, ads)
+}
+
+// syncCampaignAds re-syncs the cached entries of every ad in the campaign, after the campaign status or window changed
+func syncCampaignAds(ctx context.Context, campaignID uuid.UUID) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+
+	ids, err := database.FindAdIDsByCampaign(ctx, campaignID)
+	if err != nil {
+		// the cached ads of the campaign will be updated on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error finding ads of campaign", zap.Error(err))
+		return
+	}
+	for _, id := range ids {
+		ad, err := database.GetAd(ctx, id)
+		if err == nil {
+			err = syncCachedAd(ctx, cacheService, ad)
+		}
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error updating cached ad of campaign", zap.Error(err), zap.String("adId", id.String()))
+		}
+	}
+}
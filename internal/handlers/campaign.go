@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+var errUnknownAdvertiser = errors.New("advertiser doesn't exist")
+
+type PostCampaignRequest struct {
+	AdvertiserID uuid.UUID `json:"advertiser_id"`
+	Name         string    `json:"name"`
+	StartAt      time.Time `json:"start_at"`
+	EndAt        time.Time `json:"end_at"`
+}
+
+// PatchCampaignRequest only updates the fields that are present, pausing a campaign stops serving all of its ads
+type PatchCampaignRequest struct {
+	Name    *string        `json:"name"`
+	StartAt *time.Time     `json:"start_at"`
+	EndAt   *time.Time     `json:"end_at"`
+	Status  *models.Status `json:"status"`
+}
+
+func PostCampaignHandler(writer http.ResponseWriter, request *http.Request) {
+	reqBody := PostCampaignRequest{}
+	err := json.NewDecoder(request.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = validateCampaign(models.Campaign{Name: reqBody.Name, StartAt: reqBody.StartAt, EndAt: reqBody.EndAt, Status: models.StatusActive})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := postCampaign(request.Context(), reqBody)
+	if errors.Is(err, errUnknownAdvertiser) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusCreated, campaign)
+}
+
+// ListCampaignsHandler lists the campaigns of the advertiser given by the advertiser_id query parameter
+func ListCampaignsHandler(writer http.ResponseWriter, request *http.Request) {
+	advertiserID, err := uuid.Parse(request.URL.Query().Get("advertiser_id"))
+	if err != nil {
+		http.Error(writer, "invalid advertiser id", http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	campaigns, err := database.ListCampaigns(request.Context(), advertiserID)
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, campaigns)
+}
+
+func GetCampaignHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "campaign")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	campaign, err := database.GetCampaign(request.Context(), id)
+	if errors.Is(err, persistent.ErrCampaignNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, campaign)
+}
+
+func PatchCampaignHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "campaign")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reqBody := PatchCampaignRequest{}
+	err = json.NewDecoder(request.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := patchCampaign(request.Context(), id, reqBody)
+	var invalid errInvalidPatch
+	switch {
+	case errors.Is(err, persistent.ErrCampaignNotFound):
+		http.NotFound(writer, request)
+		return
+	case errors.As(err, &invalid):
+		http.Error(writer, invalid.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, campaign)
+}
+
+func DeleteCampaignHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "campaign")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	err = database.DeleteCampaign(request.Context(), id)
+	switch {
+	case errors.Is(err, persistent.ErrCampaignNotFound):
+		http.NotFound(writer, request)
+		return
+	case errors.Is(err, persistent.ErrCampaignHasAds):
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func postCampaign(ctx context.Context, reqBody PostCampaignRequest) (models.Campaign, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	_, err := database.GetAdvertiser(ctx, reqBody.AdvertiserID)
+	if errors.Is(err, persistent.ErrAdvertiserNotFound) {
+		return models.Campaign{}, errUnknownAdvertiser
+	}
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	campaign := models.Campaign{
+		ID:           uuid.New(),
+		AdvertiserID: reqBody.AdvertiserID,
+		Name:         reqBody.Name,
+		StartAt:      reqBody.StartAt,
+		EndAt:        reqBody.EndAt,
+		Status:       models.StatusActive,
+	}
+	err = database.InsertCampaign(ctx, campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	return campaign, nil
+}
+
+// patchCampaign updates the campaign and re-syncs the cached ads of the campaign,
+// since its status and window decide which of them are served
+func patchCampaign(ctx context.Context, id uuid.UUID, reqBody PatchCampaignRequest) (models.Campaign, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	campaign, err := database.GetCampaign(ctx, id)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	if reqBody.Name != nil {
+		campaign.Name = *reqBody.Name
+	}
+	if reqBody.StartAt != nil {
+		campaign.StartAt = *reqBody.StartAt
+	}
+	if reqBody.EndAt != nil {
+		campaign.EndAt = *reqBody.EndAt
+	}
+	if reqBody.Status != nil {
+		campaign.Status = *reqBody.Status
+	}
+	err = validateCampaign(campaign)
+	if err != nil {
+		return models.Campaign{}, errInvalidPatch{inner: err}
+	}
+
+	err = database.UpdateCampaign(ctx, campaign)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	syncCampaignAds(ctx, campaign.ID)
+	return campaign, nil
+}
+
+func validateCampaign(campaign models.Campaign) error {
+	if err := validateName(campaign.Name); err != nil {
+		return err
+	}
+	if !campaign.StartAt.Before(campaign.EndAt) {
+		return errors.New("startAt must be before endAt")
+	}
+	if !models.ValidStatus(campaign.Status) {
+		return errors.New("invalid status")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCampaignConstrainsCachedAds(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	now := time.Now().UTC()
+
+	advertiser, err := postAdvertiser(ctx, AdvertiserRequest{Name: "advertiser"})
+	require.NoError(t, err)
+	_, err = postCampaign(ctx, PostCampaignRequest{AdvertiserID: uuid.New(), Name: "campaign", StartAt: now, EndAt: now.Add(time.Hour)})
+	assert.ErrorIs(t, err, errUnknownAdvertiser)
+
+	campaign, err := postCampaign(ctx, PostCampaignRequest{
+		AdvertiserID: advertiser.ID,
+		Name:         "campaign",
+		StartAt:      now.Add(-time.Hour),
+		EndAt:        now.Add(time.Hour),
+	})
+	require.NoError(t, err)
+
+	unknown := uuid.New()
+	_, err = postAd(ctx, PostAdRequest{Title: "ad", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour), CampaignID: &unknown})
+	assert.ErrorIs(t, err, errUnknownCampaign)
+
+	response, err := postAd(ctx, PostAdRequest{Title: "ad", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour), CampaignID: &campaign.ID})
+	require.NoError(t, err)
+
+	//the cached ad ends with its campaign
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, response.AdID, cached[0].ID.String())
+	assert.WithinDuration(t, campaign.EndAt, cached[0].EndAt, time.Second)
+
+	paused := models.StatusPaused
+	_, err = patchCampaign(ctx, campaign.ID, PatchCampaignRequest{Status: &paused})
+	require.NoError(t, err)
+	cached, err = cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+
+	active := models.StatusActive
+	_, err = patchCampaign(ctx, campaign.ID, PatchCampaignRequest{Status: &active})
+	require.NoError(t, err)
+	cached, err = cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	assert.Len(t, cached, 1)
+
+	invalid := models.Status("deleted")
+	_, err = patchCampaign(ctx, campaign.ID, PatchCampaignRequest{Status: &invalid})
+	var invalidPatch errInvalidPatch
+	assert.ErrorAs(t, err, &invalidPatch)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"advertise_service/internal/signer"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// ClickPathPrefix is the path of the click redirect, followed by the token
+const ClickPathPrefix = "/c/"
+
+var errNoLandingPage = errors.New("ad has no click url")
+
+// ClickLinks builds the signed click urls returned by get ads, so clicks go through the click redirect
+type ClickLinks struct {
+	Signer signer.Signer
+	// BaseURL is prepended to the click path, such as https://ads.example.com, when empty the urls are relative to the service
+	BaseURL string
+}
+
+// link returns the signed click url of an impression of the ad
+func (l ClickLinks) link(adID uuid.UUID, params models.ConditionParams, now time.Time) (string, error) {
+	token, err := l.Signer.Sign(adID, params, now)
+	if err != nil {
+		return "", err
+	}
+	return l.BaseURL + ClickPathPrefix + token, nil
+}
+
+// ClickRedirectHandler records the click of a signed click url and redirects to the landing page of the ad
+func ClickRedirectHandler(writer http.ResponseWriter, request *http.Request) {
+	landingURL, err := clickThrough(request.Context(), request.PathValue("token"))
+	switch {
+	case errors.Is(err, signer.ErrInvalidToken):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, signer.ErrExpiredToken):
+		http.Error(writer, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, persistent.ErrAdNotFound), errors.Is(err, errNoLandingPage):
+		http.NotFound(writer, request)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(writer, request, landingURL, http.StatusFound)
+}
+
+// clickThrough verifies the token, records the click with the targeting params of the impression and returns the landing url.
+// Only the first click of an impression is recorded, replaying the token still redirects.
+func clickThrough(ctx context.Context, token string) (string, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	links := ctx.Value(ClickLinksContextKey{}).(ClickLinks)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	click, err := links.Signer.Verify(token, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	ad, err := database.GetAd(ctx, click.AdID)
+	if err != nil {
+		return "", err
+	}
+	if ad.ClickURL == "" {
+		return "", errNoLandingPage
+	}
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	first, err := cacheService.ClaimClick(ctx, click.ImpressionID, time.Unix(click.ExpiresAt, 0))
+	if err != nil {
+		// the click can't be told apart from a replay, so it isn't recorded rather than risking counting it twice
+		logger.Log(zap.ErrorLevel, "error claiming click", zap.Error(err))
+		return ad.ClickURL, nil
+	}
+	if first {
+		recordEvent(ctx, click.AdID, models.Click, click.Params)
+	}
+	return ad.ClickURL, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"advertise_service/internal/events"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"advertise_service/internal/signer"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClickRedirect(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	_, err := postAd(ctx, PostAdRequest{
+		Title:    "landing",
+		StartAt:  now.Add(-time.Hour),
+		EndAt:    now.Add(time.Hour),
+		Creative: models.Creative{ClickURL: "https://example.com/landing"},
+	})
+	require.NoError(t, err)
+	_, err = postAd(ctx, PostAdRequest{
+		Title:   "no landing",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(2 * time.Hour),
+	})
+	require.NoError(t, err)
+
+	request := GetAdsRequest{Limit: 2, Age: 24, Gender: models.Female, Country: models.Japan, Platform: models.Ios}
+	response, err := fetchMatched(ctx, request)
+	require.NoError(t, err)
+	require.Len(t, response.Items, 2)
+	assert.Empty(t, response.Items[1].ClickURL)
+	token := strings.TrimPrefix(response.Items[0].ClickURL, ClickPathPrefix)
+
+	redirect := func(ctx context.Context, token string) *httptest.ResponseRecorder {
+		httpRequest := httptest.NewRequest(http.MethodGet, ClickPathPrefix+token, nil).WithContext(ctx)
+		httpRequest.SetPathValue("token", token)
+		recorder := httptest.NewRecorder()
+		ClickRedirectHandler(recorder, httpRequest)
+		return recorder
+	}
+
+	t.Run("Redirect", func(t *testing.T) {
+		recorder := redirect(ctx, token)
+		require.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, "https://example.com/landing", recorder.Header().Get("Location"))
+
+		//the click is recorded with the targeting params of the impression
+		require.NoError(t, ctx.Value(RecorderContextKey{}).(*events.Recorder).Flush(ctx))
+		storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+		report, err := storage.ReportEvents(ctx, uuid.MustParse(response.Items[0].AdID), now, now)
+		require.NoError(t, err)
+		require.Len(t, report, 1)
+		assert.Equal(t, int64(1), report[0].Clicks)
+		assert.Equal(t, models.Japan, report[0].Country)
+
+		//replaying the token still redirects, but the click isn't counted again
+		recorder = redirect(ctx, token)
+		require.Equal(t, http.StatusFound, recorder.Code)
+		require.NoError(t, ctx.Value(RecorderContextKey{}).(*events.Recorder).Flush(ctx))
+		report, err = storage.ReportEvents(ctx, uuid.MustParse(response.Items[0].AdID), now, now)
+		require.NoError(t, err)
+		require.Len(t, report, 1)
+		assert.Equal(t, int64(1), report[0].Clicks)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, redirect(ctx, token+"x").Code)
+		assert.Equal(t, http.StatusBadRequest, redirect(ctx, "garbage").Code)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := ClickLinks{Signer: signer.New([]byte("test"), -time.Minute)}
+		link, err := expired.link(uuid.MustParse(response.Items[0].AdID), models.ConditionParams{}, now)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, redirect(ctx, strings.TrimPrefix(link, ClickPathPrefix)).Code)
+	})
+}
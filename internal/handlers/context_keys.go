@@ -5,3 +5,15 @@ type StorageContextKey struct {
 
 type CacheContextKey struct {
 }
+
+type TargetingContextKey struct {
+}
+
+type RecorderContextKey struct {
+}
+
+type RankingContextKey struct {
+}
+
+type ClickLinksContextKey struct {
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// rankedCursorPrefix marks cursors of the rankings that don't follow the cache order
+const rankedCursorPrefix = "r"
+
+// Cursor is where the previous page of get ads stopped, the zero value starts from the beginning.
+// With the end time ranking the page resumes after Position in the cache, which stays correct when ads change between pages.
+// Other rankings resume at Offset in the order reproduced from Seed.
+type Cursor struct {
+	Position cache.Cursor
+	Seed     uint64
+	Offset   int
+}
+
+// IsZero reports if the cursor starts from the beginning
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// encodeCursor turns a cursor into an opaque string for clients
+func encodeCursor(cursor Cursor) string {
+	raw := strconv.FormatInt(cursor.Position.EndAt, 10) + ":" + cursor.Position.ID.String()
+	if cursor.Position.IsZero() {
+		raw = rankedCursorPrefix + ":" + strconv.FormatUint(cursor.Seed, 10) + ":" + strconv.Itoa(cursor.Offset)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor returned by encodeCursor, an empty string is the zero cursor
+func decodeCursor(encoded string) (Cursor, error) {
+	if encoded == "" {
+		return Cursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	if rest, ranked := strings.CutPrefix(string(raw), rankedCursorPrefix+":"); ranked {
+		return decodeRankedCursor(rest)
+	}
+	endAtStr, idStr, found := strings.Cut(string(raw), ":")
+	if !found {
+		return Cursor{}, errInvalidCursor
+	}
+	endAt, err := strconv.ParseInt(endAtStr, 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Position: cache.Cursor{EndAt: endAt, ID: id}}, nil
+}
+
+func decodeRankedCursor(raw string) (Cursor, error) {
+	seedStr, offsetStr, found := strings.Cut(raw, ":")
+	if !found {
+		return Cursor{}, errInvalidCursor
+	}
+	seed, err := strconv.ParseUint(seedStr, 10, 64)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil || offset < 0 {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Seed: seed, Offset: offset}, nil
+}
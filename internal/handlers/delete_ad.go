@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+func DeleteAdHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = deleteAd(request.Context(), id)
+	if errors.Is(err, persistent.ErrAdNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func deleteAd(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	err := database.DeleteAd(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = cacheService.RemoveActiveAd(ctx, id)
+	if err != nil {
+		// the ad is already gone from the database, the stale entry will be dropped on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error removing deleted ad from cache", zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestDeleteAd(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	testAd := mock.GenerateMockAds()[1]
+	created, err := postAd(ctx, PostAdRequest{
+		Title:   testAd.Title,
+		StartAt: testAd.StartAt,
+		EndAt:   testAd.EndAt,
+	})
+	require.NoError(t, err)
+	id := uuid.MustParse(created.AdID)
+
+	require.NoError(t, deleteAd(ctx, id))
+
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	_, err = storage.GetAd(ctx, id)
+	assert.ErrorIs(t, err, persistent.ErrAdNotFound)
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	assert.Empty(t, cached)
+
+	assert.ErrorIs(t, deleteAd(ctx, id), persistent.ErrAdNotFound)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"advertise_service/internal/events"
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"advertise_service/internal/targeting"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// MaxReportDays limits the range of a single report
+const MaxReportDays = 366
+
+var errInactiveAd = errors.New("ad isn't active")
+
+type ReportResponse struct {
+	AdID string                  `json:"adId"`
+	From string                  `json:"from"`
+	To   string                  `json:"to"`
+	Rows []models.EventReportRow `json:"rows"`
+}
+
+// ImpressionHandler records an impression, clicks are only recorded by ClickRedirectHandler from a signed token
+func ImpressionHandler(writer http.ResponseWriter, request *http.Request) {
+	recordEventHandler(writer, request, models.Impression)
+}
+
+// recordEventHandler accepts an event keyed by the ad id in the path and the targeting params in the query
+func recordEventHandler(writer http.ResponseWriter, request *http.Request, kind models.EventKind) {
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params, err := parseConditionParams(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = checkActiveAd(request.Context(), id)
+	if errors.Is(err, errInactiveAd) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	recordEvent(request.Context(), id, kind, params)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// checkActiveAd returns errInactiveAd unless the ad is one of the active ads served by get ads,
+// so events of unknown or ended ads aren't stored
+func checkActiveAd(ctx context.Context, adID uuid.UUID) error {
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	db := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	engine := ctx.Value(TargetingContextKey{}).(*targeting.Engine)
+	index, err := getActiveIndex(ctx, engine, cacheService, db)
+	if err != nil {
+		return err
+	}
+	if !index.Contains(adID) {
+		return errInactiveAd
+	}
+	return nil
+}
+
+// recordEvent buffers the event, it's written to the storage by the events.Recorder later
+func recordEvent(ctx context.Context, adID uuid.UUID, kind models.EventKind, params models.ConditionParams) {
+	recorder := ctx.Value(RecorderContextKey{}).(*events.Recorder)
+	recorder.Record(models.Event{
+		ID:         uuid.New(),
+		AdID:       adID,
+		Kind:       kind,
+		OccurredAt: time.Now().UTC(),
+		Params:     params,
+	})
+}
+
+func ReportHandler(writer http.ResponseWriter, request *http.Request) {
+	logger := request.Context().Value(logging.LoggerContextKey{}).(*zap.Logger)
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseReportRange(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := reportEvents(request.Context(), id, from, to)
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(response)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error encoding response", zap.Error(err))
+		http.Error(writer, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+func reportEvents(ctx context.Context, adID uuid.UUID, from time.Time, to time.Time) (ReportResponse, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	rows, err := database.ReportEvents(ctx, adID, from, to)
+	if err != nil {
+		return ReportResponse{}, err
+	}
+	return ReportResponse{
+		AdID: adID.String(),
+		From: from.Format(time.DateOnly),
+		To:   to.Format(time.DateOnly),
+		Rows: rows,
+	}, nil
+}
+
+// parseReportRange helper function for parsing the inclusive days of a report, defaults to the last 7 days
+func parseReportRange(request *http.Request) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -6)
+	to := today
+
+	var err error
+	if fromStr := request.URL.Query().Get("from"); fromStr != "" {
+		from, err = time.Parse(time.DateOnly, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from, expected YYYY-MM-DD")
+		}
+	}
+	if toStr := request.URL.Query().Get("to"); toStr != "" {
+		to, err = time.Parse(time.DateOnly, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to, expected YYYY-MM-DD")
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("from must not be after to")
+	}
+	if to.Sub(from) > MaxReportDays*24*time.Hour {
+		return time.Time{}, time.Time{}, errors.New("report range too long")
+	}
+	return from, to, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"advertise_service/internal/events"
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	adID := uuid.New()
+	female := models.ConditionParams{Age: 24, Gender: models.Female, Country: models.Taiwan, Platform: models.Ios}
+	male := models.ConditionParams{Age: 31, Gender: models.Male, Country: models.Taiwan, Platform: models.Ios}
+
+	recordEvent(ctx, adID, models.Impression, female)
+	recordEvent(ctx, adID, models.Impression, female)
+	recordEvent(ctx, adID, models.Click, female)
+	recordEvent(ctx, adID, models.Impression, male)
+	recordEvent(ctx, uuid.New(), models.Impression, male)
+
+	recorder := ctx.Value(RecorderContextKey{}).(*events.Recorder)
+	require.NoError(t, recorder.Flush(ctx))
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	response, err := reportEvents(ctx, adID, today, today)
+	require.NoError(t, err)
+	require.Len(t, response.Rows, 2)
+	assert.Equal(t, models.Female, response.Rows[0].Gender)
+	assert.Equal(t, int64(2), response.Rows[0].Impressions)
+	assert.Equal(t, int64(1), response.Rows[0].Clicks)
+	assert.Equal(t, models.Male, response.Rows[1].Gender)
+	assert.Equal(t, int64(1), response.Rows[1].Impressions)
+	assert.Equal(t, int64(0), response.Rows[1].Clicks)
+}
+
+func TestImpressionHandler(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	created, err := postAd(ctx, PostAdRequest{Title: "served", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	impression := func(id string) int {
+		httpRequest := httptest.NewRequest(http.MethodPost, "/api/v1/ad/"+id+"/impression?age=24&gender=F&country=TW&platform=ios", nil).WithContext(ctx)
+		httpRequest.SetPathValue("id", id)
+		recorder := httptest.NewRecorder()
+		ImpressionHandler(recorder, httpRequest)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusAccepted, impression(created.AdID))
+	//events of ads that aren't served are rejected instead of stored
+	assert.Equal(t, http.StatusNotFound, impression(uuid.NewString()))
+
+	//cached ads that haven't started or are paused aren't served either
+	upcoming, err := postAd(ctx, PostAdRequest{Title: "upcoming", StartAt: now.Add(30 * time.Minute), EndAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, impression(upcoming.AdID))
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	paused := models.Ad{ID: uuid.New(), Title: "paused", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Status: models.StatusPaused}
+	require.NoError(t, cacheService.WriteActiveAd(ctx, paused))
+	assert.Equal(t, http.StatusNotFound, impression(paused.ID.String()))
+}
+
+func TestParseReportRange(t *testing.T) {
+	request, err := http.NewRequest("GET", "/report?from=2024-03-01&to=2024-03-31", nil)
+	require.NoError(t, err)
+	from, to, err := parseReportRange(request)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), to)
+
+	request, err = http.NewRequest("GET", "/report?from=2024-03-31&to=2024-03-01", nil)
+	require.NoError(t, err)
+	_, _, err = parseReportRange(request)
+	assert.Error(t, err)
+}
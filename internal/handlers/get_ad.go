@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+func GetAdHandler(writer http.ResponseWriter, request *http.Request) {
+	logger := request.Context().Value(logging.LoggerContextKey{}).(*zap.Logger)
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ad, err := getAd(request.Context(), id)
+	if errors.Is(err, persistent.ErrAdNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(ad)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error encoding response", zap.Error(err))
+		http.Error(writer, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+func getAd(ctx context.Context, id uuid.UUID) (models.Ad, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	return database.GetAd(ctx, id)
+}
+
+// parseAdID helper function for parsing the ad id in the request path
+func parseAdID(request *http.Request) (uuid.UUID, error) {
+	return parsePathID(request, "ad")
+}
+
+// parsePathID parses the id in the request path, name is the kind of resource reported in the error
+func parsePathID(request *http.Request, name string) (uuid.UUID, error) {
+	id, err := uuid.Parse(request.PathValue("id"))
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("invalid %s id", name)
+	}
+	return id, nil
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGetSingleAd(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	testAd := mock.GenerateMockAds()[0]
+	require.NoError(t, storage.InsertAd(ctx, testAd))
+
+	ad, err := getAd(ctx, testAd.ID)
+	require.NoError(t, err)
+	assert.Equal(t, testAd.Title, ad.Title)
+	assert.Equal(t, testAd.Conditions, ad.Conditions)
+
+	_, err = getAd(ctx, uuid.New())
+	assert.ErrorIs(t, err, persistent.ErrAdNotFound)
+}
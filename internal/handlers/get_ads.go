@@ -3,38 +3,57 @@ package handlers
 import (
 	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
 	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/infra/tracing"
 	"advertise_service/internal/models"
+	"advertise_service/internal/ranking"
+	"advertise_service/internal/targeting"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
 	"time"
 )
 
+const MaxViewerLength = 128
+
 type GetAdsRequest struct {
-	Offset   int
+	// Cursor is where the previous page stopped, the zero value starts from the beginning
+	Cursor Cursor
+	// Viewer optionally identifies who is viewing the ads, frequency caps are only applied when it's present
+	Viewer   string
 	Limit    int
 	Age      int
 	Gender   models.Gender
 	Platform models.Platform
 	Country  models.Country
+	// Attributes are the registered custom attributes of the viewer
+	Attributes map[string]string
 }
 
 type GetAdsResponse struct {
 	Items []item `json:"items"`
-	//if there are no more active ads
-	End bool `json:"end"`
+	// NextCursor resumes the scan on the next page, it's omitted when there are no more active ads
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type item struct {
-	AdID  string    `json:"adId"`
-	Title string    `json:"title"`
-	EndAt time.Time `json:"endAt"`
+	AdID        string `json:"adId"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"imageUrl,omitempty"`
+	// ClickURL is a signed url of the click redirect for this impression, it's omitted for ads without a landing page
+	ClickURL     string    `json:"clickUrl,omitempty"`
+	CallToAction string    `json:"callToAction,omitempty"`
+	EndAt        time.Time `json:"endAt"`
 }
 
 func GetAdsHandler(writer http.ResponseWriter, request *http.Request) {
@@ -61,79 +80,216 @@ func GetAdsHandler(writer http.ResponseWriter, request *http.Request) {
 }
 
 // logic
-func fetchMatched(ctx context.Context, reqParams GetAdsRequest) (GetAdsResponse, error) {
+// fetchMatched matches the active ads against the targeting index, and returns the page of matched ads after the cursor.
+func fetchMatched(ctx context.Context, reqParams GetAdsRequest) (_ GetAdsResponse, err error) {
+	ctx, span := tracing.Start(ctx, "fetchMatched")
+	defer func() { tracing.End(span, err) }()
 	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
 	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
 	db := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	engine := ctx.Value(TargetingContextKey{}).(*targeting.Engine)
+	ranker := ctx.Value(RankingContextKey{}).(ranking.Ranker)
+	links := ctx.Value(ClickLinksContextKey{}).(ClickLinks)
 
-	activeAds, err := getActiveAdsCacheAside(ctx, cacheService, db, reqParams.Offset, reqParams.Limit)
+	index, err := getActiveIndex(ctx, engine, cacheService, db)
 	if err != nil {
 		return GetAdsResponse{}, err
 	}
 
 	conditionParams := ExtractConditionParams(reqParams)
-	matched := 0
-	matchedAds := make([]models.Ad, 0)
-
-	for _, ad := range activeAds {
-		if ad.ShouldShow(conditionParams) {
-			logger.Log(zap.DebugLevel, "ad matched", zap.String("ad", ad.String()), zap.String("params", conditionParams.String()))
-			matched++
-			matchedAds = append(matchedAds, ad)
-		} else {
-			logger.Log(zap.DebugLevel, "ad not matched", zap.String("ad", ad.String()), zap.String("params", fmt.Sprint(conditionParams)))
+	if reqParams.Viewer != "" {
+		conditionParams.Segments = viewerSegments(ctx, cacheService, reqParams.Viewer, index.Segments())
+	}
+	matchedAds := index.Match(conditionParams)
+	logger.Log(zap.DebugLevel, "matched ads", zap.Int("matched", len(matchedAds)), zap.Int("indexed", index.Len()), zap.String("params", conditionParams.String()))
+
+	cursor := reqParams.Cursor
+	if cursor.IsZero() {
+		cursor.Seed = ranker.NewSeed()
+	}
+	ranked := ranker.Strategy.Rank(matchedAds, cursor.Seed)
+	//the end time ranking keeps the order of the cache, so the page starts at the first ad after the cursor position,
+	//other rankings reproduce the order of the previous pages from the seed and continue at the offset
+	start := min(cursor.Offset, len(ranked))
+	if ranker.ByEndTime() {
+		start, _ = slices.BinarySearchFunc(ranked, cursor.Position, func(ad models.Ad, position cache.Cursor) int {
+			if position.Before(ad) {
+				return 1
+			}
+			return -1
+		})
+	}
+
+	var capped map[uuid.UUID]bool
+	if reqParams.Viewer != "" {
+		capped = cappedAds(ctx, cacheService, reqParams.Viewer, ranked[start:])
+	}
+	page, consumed := chargePage(ctx, cacheService, ranked[start:], reqParams.Limit, capped)
+	end := start + consumed
+	if reqParams.Viewer != "" {
+		err = cacheService.IncrImpressions(ctx, reqParams.Viewer, page)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error counting impressions for frequency caps", zap.Error(err))
 		}
 	}
 
 	response := GetAdsResponse{
-		Items: make([]item, matched),
-		End:   len(activeAds) < reqParams.Limit,
+		Items: make([]item, len(page)),
+	}
+	if end < len(ranked) {
+		next := Cursor{Seed: cursor.Seed, Offset: end}
+		if ranker.ByEndTime() {
+			next = Cursor{Position: cache.CursorOf(page[len(page)-1])}
+		}
+		response.NextCursor = encodeCursor(next)
 	}
 
-	for i, ad := range matchedAds {
+	now := time.Now().UTC()
+	for i, ad := range page {
 		response.Items[i] = item{
-			AdID:  ad.ID.String(),
-			Title: ad.Title,
-			EndAt: ad.EndAt,
+			AdID:         ad.ID.String(),
+			Title:        ad.Title,
+			Description:  ad.Description,
+			ImageURL:     ad.ImageURL,
+			CallToAction: ad.CallToAction,
+			EndAt:        ad.EndAt,
+		}
+		//clicks go through the click redirect, so they are measured before reaching the landing page
+		if ad.ClickURL != "" {
+			response.Items[i].ClickURL, err = links.link(ad.ID, conditionParams, now)
+			if err != nil {
+				logger.Log(zap.ErrorLevel, "error signing click url", zap.Error(err))
+				return GetAdsResponse{}, err
+			}
 		}
 	}
 
 	return response, nil
 }
 
-// get active ads with cache aside method
-func getActiveAdsCacheAside(ctx context.Context, cacheService cache.Service, db persistent.Storage, skip int, count int) ([]models.Ad, error) {
+// chargePage fills a page of up to limit ads from the candidates, charging the budget of each budgeted ad as it is served.
+// Ads whose budget is exhausted are skipped and replaced by the following candidates.
+// Ads in skip, such as the ads capped for the viewer, are passed over without being charged.
+// The page is returned with the amount of candidates that were consumed.
+// If the budgets can't be charged the budgeted ads are skipped, so a budget is never overspent.
+func chargePage(ctx context.Context, cacheService cache.Service, candidates []models.Ad, limit int, skip map[uuid.UUID]bool) ([]models.Ad, int) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	now := time.Now().UTC()
+	page := make([]models.Ad, 0, min(limit, len(candidates)))
+	consumed := 0
+	for len(page) < limit && consumed < len(candidates) {
+		next := min(consumed+limit-len(page), len(candidates))
+		var batch []models.Ad
+		for _, ad := range candidates[consumed:next] {
+			if !skip[ad.ID] {
+				batch = append(batch, ad)
+			}
+		}
+		consumed = next
+		charged, err := cacheService.ChargeBudgets(ctx, batch, now)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error charging ad budgets, skipping budgeted ads", zap.Error(err))
+		}
+		for _, ad := range batch {
+			if ad.Budget == nil || charged[ad.ID] {
+				page = append(page, ad)
+			}
+		}
+	}
+	return page, consumed
+}
+
+// viewerSegments returns which of the targeted segments the viewer is a member of.
+// If the memberships can't be read the viewer is treated as a member of none, so retargeted ads are skipped.
+func viewerSegments(ctx context.Context, cacheService cache.Service, viewer string, segments []uuid.UUID) []uuid.UUID {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	if len(segments) == 0 {
+		return nil
+	}
+	member, err := cacheService.SegmentMemberships(ctx, viewer, segments)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error reading segment memberships, skipping retargeted ads", zap.Error(err))
+		return nil
+	}
+	return member
+}
+
+// cappedAds returns the ads the viewer has already seen as many times as their frequency cap allows.
+// If the counters can't be read the ads are served uncapped, rather than failing the request.
+func cappedAds(ctx context.Context, cacheService cache.Service, viewer string, ads []models.Ad) map[uuid.UUID]bool {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	var ids []uuid.UUID
+	for _, ad := range ads {
+		if ad.FrequencyCap != nil {
+			ids = append(ids, ad.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	counts, err := cacheService.GetImpressionCounts(ctx, viewer, ids)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error reading impression counts, serving without frequency caps", zap.Error(err))
+		return nil
+	}
+	capped := map[uuid.UUID]bool{}
+	for _, ad := range ads {
+		if ad.FrequencyCap != nil && counts[ad.ID] >= ad.FrequencyCap.Max {
+			capped[ad.ID] = true
+		}
+	}
+	return capped
+}
+
+// getActiveIndex returns the targeting index of the cached active ads, which are kept up to date by the scheduler.CacheRefresher.
+// The index is only rebuilt when the cache version changes.
+// Only when the refresher has fallen behind and the cache is invalid, the ads are read from the database instead,
+// at most once every targeting.FallbackTTL per replica.
+func getActiveIndex(ctx context.Context, engine *targeting.Engine, cacheService cache.Service, db persistent.Storage) (_ *targeting.Index, err error) {
+	ctx, span := tracing.Start(ctx, "getActiveIndex")
+	defer func() { tracing.End(span, err) }()
 	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
 	valid, err := cacheService.CheckCacheValid(ctx)
 	if err != nil {
 		logger.Log(zap.ErrorLevel, "error checking cache valid", zap.Error(err))
-		return []models.Ad{}, err
+		return nil, err
 	}
 
-	now := time.Now().UTC()
-
 	if !valid {
-		logger.Log(zap.DebugLevel, "cache is invalid, fetching from database")
-		ads, err := db.FindAdsWithTime(ctx, now.Add(cache.Interval+cache.Tolerance), now)
+		metrics.CacheMiss()
+		logger.Log(zap.WarnLevel, "cache is invalid, fetching from database")
+		index, err := engine.Fallback(func() ([]models.Ad, error) {
+			now := time.Now().UTC()
+			return db.FindAdsWithTime(ctx, now, now)
+		})
 		if err != nil {
 			logger.Log(zap.ErrorLevel, "error retrieving ads from database", zap.Error(err))
-			return []models.Ad{}, err
+			return nil, err
 		}
+		return index, nil
+	}
+	metrics.CacheHit()
 
-		_, err = cacheService.Update(ctx, ads)
-		if err != nil {
-			//Update may fail if someone else is acquiring the write lock
-			//we don't have to handle it, just return the result we fetched from database instead.
-			logger.Log(zap.ErrorLevel, "error writing active ads to cache, someone else might be acquiring the write lock, reading from older cache instead", zap.Error(err))
-		}
-		//since we fetch ads that will start in the future too, we need to filter them out before returning to the client
-		ads = slices.DeleteFunc(ads, func(ad models.Ad) bool {
-			return ad.StartAt.After(now)
-		})
-		return ads[min(skip, len(ads)):min(skip+count, len(ads))], err
+	version, err := cacheService.Version(ctx)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error reading cache version", zap.Error(err))
+		return nil, err
+	}
+	if index, ok := engine.Get(version); ok {
+		return index, nil
 	}
 
-	return cacheService.GetActiveAds(ctx, skip, count)
+	ads, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error retrieving ads from cache", zap.Error(err))
+		return nil, err
+	}
+	index := targeting.NewIndex(ads)
+	engine.Set(version, index)
+	metrics.SetCachedActiveAds(len(ads))
+	logger.Log(zap.DebugLevel, "rebuilt targeting index", zap.Int64("version", version), zap.Int("ads", index.Len()))
+	return index, nil
 }
 
 // helper function for parsing request
@@ -143,50 +299,105 @@ func ExtractConditionParams(req GetAdsRequest) models.ConditionParams {
 		Gender:   req.Gender,
 		Country:  req.Country,
 		Platform: req.Platform,
+
+		Attributes: req.Attributes,
 	}
 }
 
 // ParseGetAdsRequest helper function for parsing request
 func ParseGetAdsRequest(request *http.Request) (GetAdsRequest, error) {
-	offsetStr := request.URL.Query().Get("offset")
 	limitStr := request.URL.Query().Get("limit")
-	ageStr := request.URL.Query().Get("age")
-	gender := models.Gender(request.URL.Query().Get("gender"))
-	country := models.Country(request.URL.Query().Get("country"))
-	platform := models.Platform(request.URL.Query().Get("platform"))
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	cursor, err := decodeCursor(request.URL.Query().Get("cursor"))
+	if err != nil {
+		return GetAdsRequest{}, err
 	}
 	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 0 {
+	if err != nil || limit <= 0 {
 		limit = 5
 	}
-	age, err := strconv.Atoi(ageStr)
+	params, err := parseConditionParams(request.URL.Query())
 	if err != nil {
 		return GetAdsRequest{}, err
 	}
+	viewer := request.URL.Query().Get("viewer")
+	if len(viewer) > MaxViewerLength {
+		return GetAdsRequest{}, errors.New("viewer too long")
+	}
+
+	parsed := GetAdsRequest{
+		Cursor:   cursor,
+		Viewer:   viewer,
+		Limit:    limit,
+		Age:      params.Age,
+		Gender:   params.Gender,
+		Country:  params.Country,
+		Platform: params.Platform,
+
+		Attributes: params.Attributes,
+	}
+	return parsed, nil
+}
+
+// parseConditionParams helper function for parsing the targeting params of the viewer in the query
+func parseConditionParams(query url.Values) (models.ConditionParams, error) {
+	ageStr := query.Get("age")
+	gender := models.Gender(query.Get("gender"))
+	country := models.Country(query.Get("country"))
+	platform := models.Platform(query.Get("platform"))
+	age, err := strconv.Atoi(ageStr)
+	if err != nil {
+		return models.ConditionParams{}, err
+	}
 	if age < 0 {
-		return GetAdsRequest{}, errors.New("age cannot be negative")
+		return models.ConditionParams{}, errors.New("age cannot be negative")
 	}
 
 	if !models.ValidCountry(country) {
-		return GetAdsRequest{}, errors.New("invalid country")
+		return models.ConditionParams{}, errors.New("invalid country")
 	}
 	if !models.ValidPlatform(platform) {
-		return GetAdsRequest{}, errors.New("invalid platform")
+		return models.ConditionParams{}, errors.New("invalid platform")
 	}
 	if !models.ValidGender(gender) {
-		return GetAdsRequest{}, errors.New("invalid gender")
+		return models.ConditionParams{}, errors.New("invalid gender")
 	}
 
-	parsed := GetAdsRequest{
-		Offset:   offset,
-		Limit:    limit,
+	attributes, err := parseAttributes(query)
+	if err != nil {
+		return models.ConditionParams{}, err
+	}
+
+	return models.ConditionParams{
 		Age:      age,
 		Gender:   gender,
 		Country:  country,
 		Platform: platform,
+
+		Attributes: attributes,
+	}, nil
+}
+
+// parseAttributes reads every query param that isn't reserved as a custom attribute, so unknown params are rejected
+func parseAttributes(query url.Values) (map[string]string, error) {
+	var attributes map[string]string
+	for key, values := range query {
+		if key == string(models.FieldSegment) {
+			return nil, errors.New("segments are looked up from the viewer, they can't be sent")
+		}
+		if slices.Contains(models.ReservedAttributeKeys, key) {
+			continue
+		}
+		attributeType, ok := models.LookupAttribute(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown attribute %q", key)
+		}
+		if len(values) != 1 || !models.ValidAttributeValue(attributeType, values[0]) {
+			return nil, fmt.Errorf("invalid %s", key)
+		}
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		attributes[key] = values[0]
 	}
-	return parsed, nil
+	return attributes, nil
 }
@@ -1,28 +1,63 @@
 package handlers
 
 import (
+	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/persistent"
 	"advertise_service/internal/mock"
 	"advertise_service/internal/models"
-	"advertise_service/internal/utils"
+	"advertise_service/internal/ranking"
+	"advertise_service/internal/scheduler"
 	"context"
+	"fmt"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestParseRequest(t *testing.T) {
-	request, err := http.NewRequest("GET", "/ad?limit=3&offset=5&age=24&gender=F&country=TW&platform=ios", nil)
+	cursor := Cursor{Position: cache.Cursor{EndAt: 1700000000, ID: uuid.New()}}
+	request, err := http.NewRequest("GET", "/ad?limit=3&viewer=user-1&cursor="+encodeCursor(cursor)+"&age=24&gender=F&country=TW&platform=ios", nil)
 	assert.NoError(t, err)
 	req, err := ParseGetAdsRequest(request)
 	require.NoError(t, err)
 	assert.Equal(t, 3, req.Limit)
-	assert.Equal(t, 5, req.Offset)
+	assert.Equal(t, cursor, req.Cursor)
+	assert.Equal(t, "user-1", req.Viewer)
 	assert.Equal(t, 24, req.Age)
 	assert.Equal(t, models.Female, req.Gender)
 	assert.Equal(t, models.Taiwan, req.Country)
 	assert.Equal(t, models.Ios, req.Platform)
+
+	ranked := Cursor{Seed: 42, Offset: 10}
+	request, err = http.NewRequest("GET", "/ad?cursor="+encodeCursor(ranked)+"&age=24&gender=F&country=TW&platform=ios", nil)
+	assert.NoError(t, err)
+	req, err = ParseGetAdsRequest(request)
+	require.NoError(t, err)
+	assert.Equal(t, ranked, req.Cursor)
+
+	request, err = http.NewRequest("GET", "/ad?cursor=garbage&age=24&gender=F&country=TW&platform=ios", nil)
+	assert.NoError(t, err)
+	_, err = ParseGetAdsRequest(request)
+	assert.ErrorIs(t, err, errInvalidCursor)
+
+	//custom attributes have to be registered, and their values valid for the type
+	require.NoError(t, models.RegisterAttribute("app_version", models.AttributeSemver))
+	request, err = http.NewRequest("GET", "/ad?age=24&gender=F&country=TW&platform=ios&app_version=5.2.1", nil)
+	assert.NoError(t, err)
+	req, err = ParseGetAdsRequest(request)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app_version": "5.2.1"}, req.Attributes)
+	assert.Equal(t, req.Attributes, ExtractConditionParams(req).Attributes)
+	for _, query := range []string{"app_version=latest", "app_version=5.2&app_version=5.3", "unregistered=1", "segment=" + uuid.NewString()} {
+		request, err = http.NewRequest("GET", "/ad?age=24&gender=F&country=TW&platform=ios&"+query, nil)
+		assert.NoError(t, err)
+		_, err = ParseGetAdsRequest(request)
+		assert.Error(t, err, query)
+	}
 }
 
 func TestGetAd(t *testing.T) {
@@ -36,7 +71,6 @@ func TestGetAd(t *testing.T) {
 
 	getAd := func(t *testing.T) {
 		request := GetAdsRequest{
-			Offset:   0,
 			Limit:    1000,
 			Age:      24,
 			Gender:   models.Male,
@@ -46,7 +80,8 @@ func TestGetAd(t *testing.T) {
 
 		response, err := fetchMatched(ctx, request)
 		assert.NoError(t, err)
-		utils.SortAdsByEndTimeAsc(testData)
+		assert.Empty(t, response.NextCursor)
+		slices.SortFunc(testData, cache.CompareAds)
 
 		condParam := ExtractConditionParams(request)
 		i := 0
@@ -65,7 +100,299 @@ func TestGetAd(t *testing.T) {
 	})
 
 	t.Run("GetAdFromCache", func(t *testing.T) {
+		cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+		_, err := scheduler.NewCacheRefresher(storage, cacheService, logger).Refresh(ctx)
+		require.NoError(t, err)
 		getAd(t)
 	})
 
+	t.Run("Paginate", func(t *testing.T) {
+		request := GetAdsRequest{
+			Limit:    1,
+			Age:      24,
+			Gender:   models.Female,
+			Country:  models.Taiwan,
+			Platform: models.Web,
+		}
+		condParam := ExtractConditionParams(request)
+		var expected []string
+		for _, testAd := range testData {
+			if testAd.ShouldShow(condParam) {
+				expected = append(expected, testAd.ID.String())
+			}
+		}
+		require.NotEmpty(t, expected)
+
+		var paged []string
+		for {
+			response, err := fetchMatched(ctx, request)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(response.Items), request.Limit)
+			for _, item := range response.Items {
+				paged = append(paged, item.AdID)
+			}
+			if response.NextCursor == "" {
+				break
+			}
+			request.Cursor, err = decodeCursor(response.NextCursor)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, expected, paged)
+	})
+
+}
+
+func TestFrequencyCap(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	_, err := postAd(ctx, PostAdRequest{
+		Title:        "capped",
+		StartAt:      now.Add(-time.Hour),
+		EndAt:        now.Add(time.Hour),
+		FrequencyCap: &models.FrequencyCap{Max: 2, WindowSeconds: 3600},
+	})
+	require.NoError(t, err)
+	_, err = postAd(ctx, PostAdRequest{
+		Title:   "uncapped",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(2 * time.Hour),
+	})
+	require.NoError(t, err)
+
+	request := GetAdsRequest{Limit: 10, Age: 20, Gender: models.Male, Country: models.Taiwan, Platform: models.Web, Viewer: "viewer-1"}
+	titles := func(response GetAdsResponse) []string {
+		var result []string
+		for _, item := range response.Items {
+			result = append(result, item.Title)
+		}
+		return result
+	}
+
+	for range 2 {
+		response, err := fetchMatched(ctx, request)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"capped", "uncapped"}, titles(response))
+	}
+	response, err := fetchMatched(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uncapped"}, titles(response))
+
+	//other viewers and anonymous requests are not affected
+	request.Viewer = "viewer-2"
+	response, err = fetchMatched(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"capped", "uncapped"}, titles(response))
+	request.Viewer = ""
+	response, err = fetchMatched(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"capped", "uncapped"}, titles(response))
+}
+
+func TestBudget(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	_, err := postAd(ctx, PostAdRequest{
+		Title:   "budgeted",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(time.Hour),
+		Budget:  &models.Budget{Total: 40, ImpressionCost: 10},
+	})
+	require.NoError(t, err)
+	_, err = postAd(ctx, PostAdRequest{
+		Title:   "unbudgeted",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(2 * time.Hour),
+	})
+	require.NoError(t, err)
+
+	request := GetAdsRequest{Limit: 1, Age: 20, Gender: models.Male, Country: models.Taiwan, Platform: models.Web}
+	//half of the window has passed, so the pacing allows half of the total budget
+	for range 2 {
+		response, err := fetchMatched(ctx, request)
+		require.NoError(t, err)
+		require.Len(t, response.Items, 1)
+		assert.Equal(t, "budgeted", response.Items[0].Title)
+		assert.NotEmpty(t, response.NextCursor)
+	}
+
+	//the exhausted ad is skipped and the page is filled with the next ad
+	response, err := fetchMatched(ctx, request)
+	require.NoError(t, err)
+	require.Len(t, response.Items, 1)
+	assert.Equal(t, "unbudgeted", response.Items[0].Title)
+	assert.Empty(t, response.NextCursor)
+}
+
+func TestValidateBudget(t *testing.T) {
+	request := PostAdRequest{StartAt: time.Now(), EndAt: time.Now().Add(time.Hour)}
+	for budget, valid := range map[models.Budget]bool{
+		{Total: 100, ImpressionCost: 1}:            true,
+		{Total: 100, Daily: 10, ImpressionCost: 1}: true,
+		{Total: 100}:                                false,
+		{Total: 100, ImpressionCost: 101}:           false,
+		{Total: 100, Daily: 101, ImpressionCost: 1}: false,
+		{Total: 100, Daily: 1, ImpressionCost: 2}:   false,
+	} {
+		request.Budget = &budget
+		assert.Equal(t, valid, validateRequest(request) == nil, budget)
+	}
+}
+
+func TestRanking(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	for i, priority := range []int{0, 2, 1, 2} {
+		_, err := postAd(ctx, PostAdRequest{
+			Title:    fmt.Sprintf("priority %d ends in %dh", priority, i+1),
+			StartAt:  now.Add(-time.Hour),
+			EndAt:    now.Add(time.Duration(i+1) * time.Hour),
+			Priority: priority,
+		})
+		require.NoError(t, err)
+	}
+
+	paginate := func(t *testing.T, ranker ranking.Ranker) []string {
+		ctx := context.WithValue(ctx, RankingContextKey{}, ranker)
+		request := GetAdsRequest{Limit: 1, Age: 20, Gender: models.Male, Country: models.Taiwan, Platform: models.Web}
+		var titles []string
+		for {
+			response, err := fetchMatched(ctx, request)
+			require.NoError(t, err)
+			for _, item := range response.Items {
+				titles = append(titles, item.Title)
+			}
+			if response.NextCursor == "" {
+				return titles
+			}
+			request.Cursor, err = decodeCursor(response.NextCursor)
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("EndTime", func(t *testing.T) {
+		ranker, err := ranking.NewRanker(ranking.EndTime)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"priority 0 ends in 1h", "priority 2 ends in 2h", "priority 1 ends in 3h", "priority 2 ends in 4h"}, paginate(t, ranker))
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		ranker, err := ranking.NewRanker(ranking.Priority)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"priority 2 ends in 2h", "priority 2 ends in 4h", "priority 1 ends in 3h", "priority 0 ends in 1h"}, paginate(t, ranker))
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		ranker, err := ranking.NewRanker(ranking.Weighted)
+		require.NoError(t, err)
+		ranker.NewSeed = func() uint64 { return 7 }
+
+		//later pages reproduce the order of the first page, so every ad is served exactly once
+		titles := paginate(t, ranker)
+		require.Len(t, titles, 4)
+		assert.ElementsMatch(t, []string{"priority 2 ends in 2h", "priority 2 ends in 4h"}, titles[:2])
+		assert.Equal(t, []string{"priority 1 ends in 3h", "priority 0 ends in 1h"}, titles[2:])
+		//the same seed gives the same order
+		assert.Equal(t, titles, paginate(t, ranker))
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	today := now.Weekday()
+	for title, day := range map[string]time.Weekday{"today": today, "tomorrow": (today + 1) % 7} {
+		request := PostAdRequest{
+			Title:    title,
+			StartAt:  now.Add(-time.Hour),
+			EndAt:    now.Add(time.Hour),
+			Schedule: &models.Schedule{Windows: []models.ScheduleWindow{{Days: []time.Weekday{day}, Start: "00:00", End: "24:00"}}},
+		}
+		require.NoError(t, validateRequest(request))
+		_, err := postAd(ctx, request)
+		require.NoError(t, err)
+	}
+
+	response, err := fetchMatched(ctx, GetAdsRequest{Limit: 10, Age: 20, Gender: models.Male, Country: models.Taiwan, Platform: models.Web})
+	require.NoError(t, err)
+	require.Len(t, response.Items, 1)
+	assert.Equal(t, "today", response.Items[0].Title)
+
+	invalid := PostAdRequest{
+		StartAt:  now,
+		EndAt:    now.Add(time.Hour),
+		Schedule: &models.Schedule{Timezone: "Taiwan/Taipei", Windows: []models.ScheduleWindow{{Days: []time.Weekday{today}, Start: "00:00", End: "24:00"}}},
+	}
+	assert.Error(t, validateRequest(invalid))
+}
+
+func TestTargetingExpression(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	//everyone in TW except iOS
+	request := PostAdRequest{
+		Title:   "tw except ios",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(time.Hour),
+		Targeting: &models.Expression{And: []models.Expression{
+			{Field: models.FieldCountry, Op: models.OpIn, Values: []string{"TW"}},
+			{Field: models.FieldPlatform, Op: models.OpNotIn, Values: []string{"ios"}},
+		}},
+	}
+	require.NoError(t, validateRequest(request))
+	created, err := postAd(ctx, request)
+	require.NoError(t, err)
+
+	matches := func(country models.Country, platform models.Platform) bool {
+		response, err := fetchMatched(ctx, GetAdsRequest{Limit: 10, Age: 30, Gender: models.Female, Country: country, Platform: platform})
+		require.NoError(t, err)
+		return len(response.Items) == 1
+	}
+	assert.True(t, matches(models.Taiwan, models.Web))
+	assert.True(t, matches(models.Taiwan, models.Android))
+	assert.False(t, matches(models.Taiwan, models.Ios))
+	assert.False(t, matches(models.Japan, models.Web))
+
+	//the conditions array is still accepted, and replaces the expression
+	conditions := []models.Condition{{Country: []models.Country{models.Japan}}}
+	patched, err := patchAd(ctx, uuid.MustParse(created.AdID), PatchAdRequest{Conditions: &conditions})
+	require.NoError(t, err)
+	assert.Nil(t, patched.Targeting)
+	assert.False(t, matches(models.Taiwan, models.Web))
+	assert.True(t, matches(models.Japan, models.Ios))
+
+	request.Conditions = conditions
+	assert.Error(t, validateRequest(request))
+}
+
+func TestAttributeTargeting(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	require.NoError(t, models.RegisterAttribute("board", models.AttributeString))
+	require.NoError(t, models.RegisterAttribute("app_version", models.AttributeSemver))
+	now := time.Now().UTC()
+	request := PostAdRequest{
+		Title:   "new app on the pets board",
+		StartAt: now.Add(-time.Hour),
+		EndAt:   now.Add(time.Hour),
+		Targeting: &models.Expression{And: []models.Expression{
+			{Attribute: "board", Op: models.OpEq, Values: []string{"pets"}},
+			{Attribute: "app_version", Op: models.OpGte, Values: []string{"5.2.0"}},
+		}},
+	}
+	require.NoError(t, validateRequest(request))
+	_, err := postAd(ctx, request)
+	require.NoError(t, err)
+
+	matches := func(attributes map[string]string) bool {
+		response, err := fetchMatched(ctx, GetAdsRequest{Limit: 10, Age: 30, Gender: models.Female, Country: models.Taiwan, Platform: models.Web, Attributes: attributes})
+		require.NoError(t, err)
+		return len(response.Items) == 1
+	}
+	assert.True(t, matches(map[string]string{"board": "pets", "app_version": "5.2.0"}))
+	assert.True(t, matches(map[string]string{"board": "pets", "app_version": "6.0"}))
+	assert.False(t, matches(map[string]string{"board": "pets", "app_version": "5.1.9"}))
+	assert.False(t, matches(map[string]string{"board": "food", "app_version": "6.0"}))
+	assert.False(t, matches(nil))
+
+	request.Targeting = &models.Expression{Attribute: "unregistered", Op: models.OpEq, Values: []string{"a"}}
+	assert.Error(t, validateRequest(request))
 }
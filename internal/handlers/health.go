@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/persistent"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ReadyCheckTimeout bounds each dependency check, so a hanging dependency fails the probe instead of stalling it
+const ReadyCheckTimeout = 2 * time.Second
+
+var errCacheNotPopulated = errors.New("active ad cache has not been populated yet")
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadyResponse struct {
+	Ready  bool                  `json:"ready"`
+	Checks map[string]CheckState `json:"checks"`
+}
+
+type CheckState struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthzHandler reports that the process is alive, it doesn't touch any dependency
+func HealthzHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(HealthResponse{Status: "ok"})
+}
+
+// ReadyzHandler reports whether postgres and redis are reachable and the active ad cache is populated,
+// responding 503 if any of them is not
+func ReadyzHandler(writer http.ResponseWriter, request *http.Request) {
+	response := readiness(request.Context())
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(response)
+}
+
+func readiness(ctx context.Context) ReadyResponse {
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+
+	checks := map[string]func(ctx context.Context) error{
+		"postgres": storage.Ping,
+		"redis":    cacheService.Ping,
+		"cache": func(ctx context.Context) error {
+			lastUpdate, err := cacheService.LastUpdate(ctx)
+			if err != nil {
+				return err
+			}
+			if lastUpdate.IsZero() {
+				return errCacheNotPopulated
+			}
+			return nil
+		},
+	}
+
+	response := ReadyResponse{Ready: true, Checks: make(map[string]CheckState, len(checks))}
+	for name, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, ReadyCheckTimeout)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			response.Ready = false
+			response.Checks[name] = CheckState{Error: err.Error()}
+		} else {
+			response.Checks[name] = CheckState{Ready: true}
+		}
+	}
+	return response
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/mock"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+
+	response := readiness(ctx)
+	assert.False(t, response.Ready)
+	assert.True(t, response.Checks["postgres"].Ready)
+	assert.True(t, response.Checks["redis"].Ready)
+	assert.False(t, response.Checks["cache"].Ready)
+	assert.Equal(t, errCacheNotPopulated.Error(), response.Checks["cache"].Error)
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	_, err := cacheService.Update(ctx, mock.GenerateMockAds(), 0)
+	require.NoError(t, err)
+
+	response = readiness(ctx)
+	assert.True(t, response.Ready)
+	assert.True(t, response.Checks["cache"].Ready)
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MaxImportAds bounds the ads of one import, so the transaction inserting them stays short
+const MaxImportAds = 1000
+
+// MaxImportUploadBytes bounds the size of an imported file
+const MaxImportUploadBytes = 16 << 20
+
+// ImportFormat is the file format of a bulk import
+type ImportFormat string
+
+const (
+	// ImportCSV has a header row naming the columns, see importTextColumns and importJSONColumns
+	ImportCSV ImportFormat = "csv"
+	// ImportJSONL has one PostAdRequest per line
+	ImportJSONL ImportFormat = "jsonl"
+)
+
+// importTextColumns are the csv columns holding plain text, they are named after the json fields of PostAdRequest
+var importTextColumns = []string{"title", "start_at", "end_at", "campaign_id", "description", "image_url", "click_url", "call_to_action"}
+
+// importJSONColumns are the csv columns holding a json value of the PostAdRequest field with the same name
+var importJSONColumns = []string{"conditions", "targeting", "frequency_cap", "budget", "schedule", "priority", "weight"}
+
+// errInvalidImport reports a file that can't be imported at all, as opposed to the errors of single rows
+type errInvalidImport struct {
+	inner error
+}
+
+func (e errInvalidImport) Error() string {
+	return e.inner.Error()
+}
+
+type ImportAdsResponse struct {
+	// AdIDs are the created ads in the order of the rows, nothing is created if any row is invalid
+	AdIDs []string `json:"adIds,omitempty"`
+	// Errors reports every invalid row
+	Errors []ImportRowError `json:"errors,omitempty"`
+}
+
+type ImportRowError struct {
+	// Row is the line of the file where the ad starts, the csv header is line 1
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// importRow is a parsed ad of an import, err is set if the row couldn't be parsed
+type importRow struct {
+	line    int
+	request PostAdRequest
+	err     error
+}
+
+// ParseImportFormat parses the name of an import format, such as the extension of a file
+func ParseImportFormat(name string) (ImportFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "csv":
+		return ImportCSV, nil
+	case "jsonl", "ndjson":
+		return ImportJSONL, nil
+	default:
+		return "", fmt.Errorf("unsupported import format %q", name)
+	}
+}
+
+// ImportAdsHandler creates every ad of the uploaded file, the format is given by the format query parameter or the content type.
+// Invalid rows are reported with 400 and nothing is imported.
+func ImportAdsHandler(writer http.ResponseWriter, request *http.Request) {
+	format, err := importFormat(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	response, err := ImportAds(request.Context(), http.MaxBytesReader(writer, request.Body, MaxImportUploadBytes), format)
+	var invalid errInvalidImport
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(writer, "import file too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.As(err, &invalid):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(response.Errors) > 0 {
+		writeResource(writer, request, http.StatusBadRequest, response)
+		return
+	}
+	writeResource(writer, request, http.StatusCreated, response)
+}
+
+func importFormat(request *http.Request) (ImportFormat, error) {
+	if format := request.URL.Query().Get("format"); format != "" {
+		return ParseImportFormat(format)
+	}
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return "", errors.New("content type must be text/csv or application/jsonl")
+	}
+	switch mediaType {
+	case "text/csv":
+		return ImportCSV, nil
+	case "application/jsonl", "application/x-ndjson":
+		return ImportJSONL, nil
+	default:
+		return "", fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+// ImportAds validates every row of the file like a PostAdRequest and inserts all of them in one transaction.
+// If any row is invalid nothing is inserted and the response reports the invalid rows.
+// The imported ads that are active are pushed to the cache in one batch.
+func ImportAds(ctx context.Context, reader io.Reader, format ImportFormat) (ImportAdsResponse, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	var rows []importRow
+	var err error
+	switch format {
+	case ImportCSV:
+		rows, err = parseImportCSV(reader)
+	case ImportJSONL:
+		rows, err = parseImportJSONL(reader)
+	default:
+		err = errInvalidImport{inner: fmt.Errorf("unsupported import format %q", format)}
+	}
+	if err != nil {
+		return ImportAdsResponse{}, err
+	}
+	if len(rows) == 0 {
+		return ImportAdsResponse{}, errInvalidImport{inner: errors.New("no ads to import")}
+	}
+
+	response := ImportAdsResponse{}
+	campaigns := map[uuid.UUID]models.Campaign{}
+	ads := make([]models.Ad, 0, len(rows))
+	for _, row := range rows {
+		err := row.err
+		if err == nil {
+			err = validateRequest(row.request)
+		}
+		if err != nil {
+			response.Errors = append(response.Errors, ImportRowError{Row: row.line, Error: err.Error()})
+			continue
+		}
+		ad := newAd(row.request)
+		err = importCampaign(ctx, database, campaigns, ad.CampaignID)
+		if err == nil {
+			err = checkSegments(ctx, database, ad)
+		}
+		if errors.Is(err, errUnknownCampaign) || errors.Is(err, errUnknownSegment) {
+			response.Errors = append(response.Errors, ImportRowError{Row: row.line, Error: err.Error()})
+			continue
+		}
+		if err != nil {
+			return ImportAdsResponse{}, err
+		}
+		ads = append(ads, ad)
+	}
+	if len(response.Errors) > 0 {
+		return response, nil
+	}
+
+	err = database.InsertAds(ctx, ads)
+	if err != nil {
+		var insertErr persistent.InsertAdsError
+		if errors.As(err, &insertErr) {
+			logger.Log(zap.ErrorLevel, "error importing ads", zap.Int("row", rows[insertErr.Index].line), zap.Error(err))
+		}
+		return ImportAdsResponse{}, err
+	}
+
+	cached := make([]models.Ad, 0, len(ads))
+	for _, ad := range ads {
+		response.AdIDs = append(response.AdIDs, ad.ID.String())
+		if ad.CampaignID != nil {
+			var served bool
+			ad, served = campaigns[*ad.CampaignID].Constrain(ad)
+			if !served {
+				continue
+			}
+		}
+		cached = append(cached, ad)
+	}
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = cacheAds(ctx, cacheService, cached)
+	if err != nil {
+		// the imported ads are committed, the scheduler.CacheRefresher will cache them on its next refresh
+		logger.Log(zap.ErrorLevel, "error caching imported ads", zap.Error(err))
+	}
+	return response, nil
+}
+
+// importCampaign loads the campaign of an imported ad once per import, it returns errUnknownCampaign if it doesn't exist
+func importCampaign(ctx context.Context, database persistent.Storage, campaigns map[uuid.UUID]models.Campaign, campaignID *uuid.UUID) error {
+	if campaignID == nil {
+		return nil
+	}
+	if _, ok := campaigns[*campaignID]; ok {
+		return nil
+	}
+	campaign, err := database.GetCampaign(ctx, *campaignID)
+	if errors.Is(err, persistent.ErrCampaignNotFound) {
+		return errUnknownCampaign
+	}
+	if err != nil {
+		return err
+	}
+	campaigns[*campaignID] = campaign
+	return nil
+}
+
+// parseImportCSV reads a csv file whose header names the columns, empty cells are left out of the ad.
+// A row that can't be parsed is reported as invalid, a malformed file fails the whole import.
+func parseImportCSV(reader io.Reader) ([]importRow, error) {
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, importReadError(err)
+	}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !slices.Contains(importTextColumns, column) && !slices.Contains(importJSONColumns, column) {
+			return nil, errInvalidImport{inner: fmt.Errorf("unknown column %q", column)}
+		}
+		if slices.Contains(header[:i], column) {
+			return nil, errInvalidImport{inner: fmt.Errorf("duplicate column %q", column)}
+		}
+		header[i] = column
+	}
+
+	var rows []importRow
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, importReadError(err)
+		}
+		if len(rows) == MaxImportAds {
+			return nil, errInvalidImport{inner: fmt.Errorf("at most %d ads can be imported at once", MaxImportAds)}
+		}
+		line, _ := csvReader.FieldPos(0)
+		row := importRow{line: line}
+		if err != nil {
+			row.err = fmt.Errorf("expected %d columns", len(header))
+		} else {
+			row.request, row.err = csvAdRequest(header, record)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// csvAdRequest decodes a csv record the same way as the json body of PostAdHandler
+func csvAdRequest(header []string, record []string) (PostAdRequest, error) {
+	fields := map[string]json.RawMessage{}
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		if slices.Contains(importTextColumns, column) {
+			fields[column], _ = json.Marshal(value)
+			continue
+		}
+		if !json.Valid([]byte(value)) {
+			return PostAdRequest{}, fmt.Errorf("invalid %s", column)
+		}
+		fields[column] = json.RawMessage(value)
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return PostAdRequest{}, err
+	}
+	var request PostAdRequest
+	err = json.Unmarshal(encoded, &request)
+	return request, err
+}
+
+// parseImportJSONL reads one PostAdRequest per line, blank lines are skipped
+func parseImportJSONL(reader io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, MaxImportUploadBytes)
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+		if len(rows) == MaxImportAds {
+			return nil, errInvalidImport{inner: fmt.Errorf("at most %d ads can be imported at once", MaxImportAds)}
+		}
+		row := importRow{line: line}
+		row.err = json.Unmarshal(content, &row.request)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, importReadError(err)
+	}
+	return rows, nil
+}
+
+// importReadError keeps the errors of the underlying reader, such as http.MaxBytesError, and reports the others as a malformed file
+func importReadError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) || errors.Is(err, bufio.ErrTooLong) {
+		return errInvalidImport{inner: err}
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	rows, err := parseImportCSV(strings.NewReader(`Title,start_at,end_at,conditions,priority
+first,2030-01-01T00:00:00Z,2030-02-01T00:00:00Z,"[{""country"":[""TW"",""JP""]}]",5
+
+"multi
+line",2030-01-01T00:00:00Z,2030-02-01T00:00:00Z,,
+broken,2030-01-01T00:00:00Z,2030-02-01T00:00:00Z,[{,
+short,2030-01-01T00:00:00Z
+`))
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+
+	assert.Equal(t, 2, rows[0].line)
+	require.NoError(t, rows[0].err)
+	assert.Equal(t, "first", rows[0].request.Title)
+	assert.Equal(t, time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC), rows[0].request.EndAt.UTC())
+	assert.Equal(t, []models.Condition{{Country: []models.Country{models.Taiwan, models.Japan}}}, rows[0].request.Conditions)
+	assert.Equal(t, 5, rows[0].request.Priority)
+
+	assert.Equal(t, 4, rows[1].line)
+	require.NoError(t, rows[1].err)
+	assert.Equal(t, "multi\nline", rows[1].request.Title)
+
+	assert.Equal(t, 6, rows[2].line)
+	assert.EqualError(t, rows[2].err, "invalid conditions")
+	assert.Equal(t, 7, rows[3].line)
+	assert.Error(t, rows[3].err)
+
+	for _, header := range []string{"title,budget_left", "title,title"} {
+		_, err = parseImportCSV(strings.NewReader(header + "\nad,1\n"))
+		assert.ErrorAs(t, err, &errInvalidImport{}, header)
+	}
+	_, err = parseImportCSV(strings.NewReader("title\n\"unterminated\n"))
+	assert.ErrorAs(t, err, &errInvalidImport{})
+}
+
+func TestImportAds(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	now := time.Now().UTC()
+
+	importFile := func(contentType string, body string) (*httptest.ResponseRecorder, ImportAdsResponse) {
+		httpRequest := httptest.NewRequest(http.MethodPost, "/api/v1/admin/ad/import", strings.NewReader(body)).WithContext(ctx)
+		httpRequest.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		ImportAdsHandler(recorder, httpRequest)
+		var response ImportAdsResponse
+		if strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") {
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		}
+		return recorder, response
+	}
+	count := func() int {
+		_, total, err := database.SearchAds(ctx, persistent.AdSearch{Limit: 10})
+		require.NoError(t, err)
+		return total
+	}
+
+	csvFile := fmt.Sprintf("title,start_at,end_at,conditions\nactive,%s,%s,\"[{\"\"country\"\":[\"\"TW\"\"]}]\"\nnext month,%s,%s,\n",
+		now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339),
+		now.AddDate(0, 1, 0).Format(time.RFC3339), now.AddDate(0, 2, 0).Format(time.RFC3339))
+	recorder, response := importFile("text/csv", csvFile)
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	require.Len(t, response.AdIDs, 2)
+	for i, title := range []string{"active", "next month"} {
+		ad, err := database.GetAd(ctx, uuid.MustParse(response.AdIDs[i]))
+		require.NoError(t, err)
+		assert.Equal(t, title, ad.Title)
+	}
+	//only the active ad is cached
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, response.AdIDs[0], cached[0].ID.String())
+
+	//every invalid row is reported and none of the rows are imported
+	line := func(request PostAdRequest) string {
+		encoded, err := json.Marshal(request)
+		require.NoError(t, err)
+		return string(encoded)
+	}
+	unknownCampaign := uuid.New()
+	jsonlFile := strings.Join([]string{
+		line(PostAdRequest{Title: "valid", StartAt: now, EndAt: now.Add(time.Hour)}),
+		"",
+		line(PostAdRequest{Title: "ended", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)}),
+		line(PostAdRequest{Title: "no campaign", StartAt: now, EndAt: now.Add(time.Hour), CampaignID: &unknownCampaign}),
+		`{"title": `,
+	}, "\n")
+	recorder, response = importFile("application/jsonl", jsonlFile)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Empty(t, response.AdIDs)
+	require.Len(t, response.Errors, 3)
+	assert.Equal(t, []int{3, 4, 5}, []int{response.Errors[0].Row, response.Errors[1].Row, response.Errors[2].Row})
+	assert.Equal(t, "endAt must be in the future", response.Errors[0].Error)
+	assert.Equal(t, errUnknownCampaign.Error(), response.Errors[1].Error)
+	assert.Equal(t, 2, count())
+
+	recorder, _ = importFile("application/xml", "<ads/>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+	recorder, _ = importFile("text/csv", "")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestImportAdsKeepsCacheStale(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	jsonlFile := fmt.Sprintf(`{"title": "active", "start_at": %q, "end_at": %q}`,
+		now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	response, err := ImportAds(ctx, strings.NewReader(jsonlFile), ImportJSONL)
+	require.NoError(t, err)
+	require.Len(t, response.AdIDs, 1)
+
+	//the imported ad is served right away, but the cache is still due for the scheduler.CacheRefresher to load every active ad
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	lastUpdate, err := cacheService.LastUpdate(ctx)
+	require.NoError(t, err)
+	assert.True(t, lastUpdate.IsZero())
+}
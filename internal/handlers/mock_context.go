@@ -3,28 +3,56 @@
 package handlers
 
 import (
+	"advertise_service/internal/events"
 	"advertise_service/internal/infra/logging"
 	"advertise_service/internal/mock"
+	"advertise_service/internal/ranking"
+	"advertise_service/internal/signer"
+	"advertise_service/internal/targeting"
 	"context"
 	"go.uber.org/zap"
 )
 
 var (
-	staticStorage = mock.NewStorage()
-	staticCache   = mock.NewCache()
-	logger, _     = zap.NewDevelopment()
+	staticStorage  = mock.NewStorage()
+	staticCache    = mock.NewCache()
+	staticEngine   = targeting.NewEngine()
+	staticRanker   = mockRanker()
+	staticLinks    = mockClickLinks()
+	staticRecorder = events.NewRecorder(staticStorage, logger)
+	logger, _      = zap.NewDevelopment()
 )
 
 func InjectStaticMockedResources(ctx context.Context) context.Context {
 	ctx = context.WithValue(ctx, StorageContextKey{}, staticStorage)
 	ctx = context.WithValue(ctx, CacheContextKey{}, staticCache)
+	ctx = context.WithValue(ctx, TargetingContextKey{}, staticEngine)
+	ctx = context.WithValue(ctx, RankingContextKey{}, staticRanker)
+	ctx = context.WithValue(ctx, ClickLinksContextKey{}, staticLinks)
+	ctx = context.WithValue(ctx, RecorderContextKey{}, staticRecorder)
 	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, logger)
 	return ctx
 }
 
 func InjectMockedResources(ctx context.Context) context.Context {
-	ctx = context.WithValue(ctx, StorageContextKey{}, mock.NewStorage())
+	storage := mock.NewStorage()
+	ctx = context.WithValue(ctx, StorageContextKey{}, storage)
+	ctx = context.WithValue(ctx, RecorderContextKey{}, events.NewRecorder(storage, logger))
 	ctx = context.WithValue(ctx, CacheContextKey{}, mock.NewCache())
+	ctx = context.WithValue(ctx, TargetingContextKey{}, targeting.NewEngine())
+	ctx = context.WithValue(ctx, RankingContextKey{}, mockRanker())
+	ctx = context.WithValue(ctx, ClickLinksContextKey{}, mockClickLinks())
 	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, logger)
 	return ctx
 }
+
+// mockRanker ranks by end time with a fixed seed, so tests get a deterministic order
+func mockRanker() ranking.Ranker {
+	ranker, _ := ranking.NewRanker(ranking.EndTime)
+	ranker.NewSeed = func() uint64 { return 0 }
+	return ranker
+}
+
+func mockClickLinks() ClickLinks {
+	return ClickLinks{Signer: signer.New([]byte("test"), signer.DefaultTTL)}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// PatchAdRequest only updates the fields that are present
+type PatchAdRequest struct {
+	Title      *string             `json:"title"`
+	StartAt    *time.Time          `json:"start_at"`
+	EndAt      *time.Time          `json:"end_at"`
+	Conditions *[]models.Condition `json:"conditions"`
+	// Targeting replaces the targeting expression and the conditions, an empty expression removes it
+	Targeting *models.Expression `json:"targeting"`
+	// the creative fields replace the current value when present, an empty string clears them
+	Description  *string `json:"description"`
+	ImageURL     *string `json:"image_url"`
+	ClickURL     *string `json:"click_url"`
+	CallToAction *string `json:"call_to_action"`
+	// FrequencyCap replaces the frequency cap, a cap with max 0 removes it
+	FrequencyCap *models.FrequencyCap `json:"frequency_cap"`
+	// CampaignID moves the ad to another campaign, the nil uuid takes it out of its campaign
+	CampaignID *uuid.UUID `json:"campaign_id"`
+	// Budget replaces the budget, a budget with total 0 removes it. The amount already spent is kept
+	Budget *models.Budget `json:"budget"`
+	// Schedule replaces the schedule, a schedule without windows removes it
+	Schedule *models.Schedule `json:"schedule"`
+	Priority *int             `json:"priority"`
+	Weight   *int             `json:"weight"`
+}
+
+// errInvalidPatch wraps validation errors of the patched ad, so they can be reported as bad requests
+type errInvalidPatch struct {
+	inner error
+}
+
+func (e errInvalidPatch) Error() string {
+	return e.inner.Error()
+}
+
+func PatchAdHandler(writer http.ResponseWriter, request *http.Request) {
+	logger := request.Context().Value(logging.LoggerContextKey{}).(*zap.Logger)
+	id, err := parseAdID(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqBody := PatchAdRequest{}
+	err = json.NewDecoder(request.Body).Decode(&reqBody)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ad, err := patchAd(request.Context(), id, reqBody)
+	var invalid errInvalidPatch
+	switch {
+	case errors.Is(err, persistent.ErrAdNotFound):
+		http.NotFound(writer, request)
+		return
+	case errors.As(err, &invalid), errors.Is(err, errUnknownCampaign), errors.Is(err, errUnknownSegment):
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(writer).Encode(ad)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "error encoding response", zap.Error(err))
+		http.Error(writer, "Internal Error", http.StatusInternalServerError)
+	}
+}
+
+func patchAd(ctx context.Context, id uuid.UUID, reqBody PatchAdRequest) (models.Ad, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+
+	ad, err := database.GetAd(ctx, id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+
+	if reqBody.Title != nil {
+		ad.Title = *reqBody.Title
+	}
+	if reqBody.StartAt != nil {
+		ad.StartAt = *reqBody.StartAt
+	}
+	if reqBody.EndAt != nil {
+		ad.EndAt = *reqBody.EndAt
+	}
+	//conditions are sugar for a targeting expression, so setting either one replaces the other
+	if reqBody.Conditions != nil {
+		ad.Conditions = *reqBody.Conditions
+		ad.Targeting = nil
+	}
+	if reqBody.Targeting != nil {
+		ad.Targeting = reqBody.Targeting
+		if reqBody.Conditions == nil {
+			ad.Conditions = nil
+		}
+		if reqBody.Targeting.IsZero() {
+			ad.Targeting = nil
+		}
+	}
+	if reqBody.Description != nil {
+		ad.Description = *reqBody.Description
+	}
+	if reqBody.ImageURL != nil {
+		ad.ImageURL = *reqBody.ImageURL
+	}
+	if reqBody.ClickURL != nil {
+		ad.ClickURL = *reqBody.ClickURL
+	}
+	if reqBody.CallToAction != nil {
+		ad.CallToAction = *reqBody.CallToAction
+	}
+	if reqBody.FrequencyCap != nil {
+		ad.FrequencyCap = reqBody.FrequencyCap
+		if reqBody.FrequencyCap.Max == 0 {
+			ad.FrequencyCap = nil
+		}
+	}
+	if reqBody.Budget != nil {
+		ad.Budget = reqBody.Budget
+		if reqBody.Budget.Total == 0 {
+			ad.Budget = nil
+		}
+	}
+	if reqBody.Schedule != nil {
+		ad.Schedule = reqBody.Schedule
+		if len(reqBody.Schedule.Windows) == 0 {
+			ad.Schedule = nil
+		}
+	}
+	if reqBody.Priority != nil {
+		ad.Priority = *reqBody.Priority
+	}
+	if reqBody.Weight != nil {
+		ad.Weight = *reqBody.Weight
+	}
+
+	if reqBody.CampaignID != nil {
+		ad.CampaignID = reqBody.CampaignID
+		if *reqBody.CampaignID == uuid.Nil {
+			ad.CampaignID = nil
+		}
+		err = checkCampaign(ctx, database, ad.CampaignID)
+		if err != nil {
+			return models.Ad{}, err
+		}
+	}
+
+	err = validateRequest(PostAdRequest{
+		Title:        ad.Title,
+		StartAt:      ad.StartAt,
+		EndAt:        ad.EndAt,
+		Conditions:   ad.Conditions,
+		Targeting:    ad.Targeting,
+		Creative:     ad.Creative,
+		FrequencyCap: ad.FrequencyCap,
+		Budget:       ad.Budget,
+		Schedule:     ad.Schedule,
+		Priority:     ad.Priority,
+		Weight:       ad.Weight,
+	})
+	if err != nil {
+		return models.Ad{}, errInvalidPatch{inner: err}
+	}
+	if reqBody.Conditions != nil || reqBody.Targeting != nil {
+		err = checkSegments(ctx, database, ad)
+		if err != nil {
+			return models.Ad{}, err
+		}
+	}
+
+	err = database.UpdateAd(ctx, ad)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	//the status isn't updated, the ad may have been paused or archived since it was read
+	ad, err = database.GetAd(ctx, id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = syncCachedAd(ctx, cacheService, ad)
+	if err != nil {
+		// the stale entry will be replaced on the next cache refresh
+		logger.Log(zap.ErrorLevel, "error updating cached ad", zap.Error(err))
+	}
+	return ad, nil
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatchAd(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	testAd := mock.GenerateMockAds()[0]
+	created, err := postAd(ctx, PostAdRequest{
+		Title:      testAd.Title,
+		StartAt:    testAd.StartAt,
+		EndAt:      testAd.EndAt,
+		Conditions: testAd.Conditions,
+	})
+	require.NoError(t, err)
+	id := uuid.MustParse(created.AdID)
+
+	title := "fixed typo"
+	endAt := time.Now().UTC().Add(30 * time.Minute)
+	patched, err := patchAd(ctx, id, PatchAdRequest{Title: &title, EndAt: &endAt})
+	require.NoError(t, err)
+	assert.Equal(t, title, patched.Title)
+	assert.Equal(t, testAd.Conditions, patched.Conditions)
+
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	stored, err := storage.GetAd(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, title, stored.Title)
+
+	//the cache should serve the patched ad right away
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, title, cached[0].Title)
+	assert.WithinDuration(t, endAt, cached[0].EndAt, time.Second)
+
+	t.Run("invalid", func(t *testing.T) {
+		longTitle := strings.Repeat("a", MaxTitleLength+1)
+		_, err := patchAd(ctx, id, PatchAdRequest{Title: &longTitle})
+		assert.ErrorAs(t, err, &errInvalidPatch{})
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := patchAd(ctx, uuid.New(), PatchAdRequest{Title: &title})
+		assert.ErrorIs(t, err, persistent.ErrAdNotFound)
+	})
+}
@@ -12,16 +12,49 @@ import (
 	"go.uber.org/zap"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
 )
 
 const MaxTitleLength = 100
 
+const MaxFrequencyCapWindow = 30 * 24 * time.Hour
+
+// limits of the creative fields
+const (
+	MaxDescriptionLength  = 500
+	MaxCallToActionLength = 30
+	MaxURLLength          = 2048
+)
+
+// MaxPriority and MaxWeight bound the ranking fields of an ad
+const (
+	MaxPriority = 100
+	MaxWeight   = 1000
+)
+
+var errUnknownCampaign = errors.New("campaign doesn't exist")
+
 type PostAdRequest struct {
 	Title      string             `json:"title"`
 	StartAt    time.Time          `json:"start_at"`
 	EndAt      time.Time          `json:"end_at"`
 	Conditions []models.Condition `json:"conditions"`
+	// Targeting optionally targets the ad with an expression, instead of Conditions
+	Targeting *models.Expression `json:"targeting"`
+	models.Creative
+	// FrequencyCap optionally limits the impressions per viewer
+	FrequencyCap *models.FrequencyCap `json:"frequency_cap"`
+	// CampaignID optionally puts the ad in an existing campaign
+	CampaignID *uuid.UUID `json:"campaign_id"`
+	// Budget optionally limits the spend of the ad, it's charged as impressions are served
+	Budget *models.Budget `json:"budget"`
+	// Schedule optionally restricts the ad to weekly time windows
+	Schedule *models.Schedule `json:"schedule"`
+	// Priority and Weight are used by the ranking of get ads, see ranking.Strategy
+	Priority int `json:"priority"`
+	Weight   int `json:"weight"`
 }
 
 type PostAdResponse struct {
@@ -46,7 +79,10 @@ func PostAdHandler(writer http.ResponseWriter, request *http.Request) {
 	}
 
 	response, err := postAd(request.Context(), reqBody)
-
+	if errors.Is(err, errUnknownCampaign) || errors.Is(err, errUnknownSegment) {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(writer, "Internal error", http.StatusInternalServerError)
 		return
@@ -66,33 +102,66 @@ func PostAdHandler(writer http.ResponseWriter, request *http.Request) {
 
 func postAd(ctx context.Context, reqBody PostAdRequest) (PostAdResponse, error) {
 	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
-	ad := models.Ad{
-		ID:         uuid.New(),
-		Title:      reqBody.Title,
-		StartAt:    reqBody.StartAt,
-		EndAt:      reqBody.EndAt,
-		Conditions: reqBody.Conditions,
-	}
+	ad := newAd(reqBody)
 	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
 
-	err := database.InsertAd(ctx, ad)
+	err := checkCampaign(ctx, database, ad.CampaignID)
+	if err != nil {
+		return PostAdResponse{}, err
+	}
+	err = checkSegments(ctx, database, ad)
+	if err != nil {
+		return PostAdResponse{}, err
+	}
+	err = database.InsertAd(ctx, ad)
 	if err != nil {
 		return PostAdResponse{}, err
 	}
 
-	//store ad in cache if it's active the time that it's created
-	if ad.StartAt.Before(time.Now().Add(cache.Interval + cache.Tolerance)) {
-		cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
-		err := cacheService.WriteActiveAd(ctx, ad)
-		if err != nil {
-			// It's ok that we failed to immediate cache the ad, scheduler will take care of it
-			logger.Log(zap.ErrorLevel, "error caching active ad", zap.Error(err))
-		}
+	//store ad in cache if it's active the time that it's created, only after the insert has been committed
+	//so the cache never serves an ad that doesn't exist in the database
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	err = cacheAd(ctx, cacheService, ad)
+	if err != nil {
+		// It's ok that we failed to immediate cache the ad, the scheduler.CacheRefresher will take care of it
+		logger.Log(zap.ErrorLevel, "error caching active ad", zap.Error(err))
 	}
 
 	return PostAdResponse{AdID: ad.ID.String()}, nil
 }
 
+// newAd creates an active ad from a validated request
+func newAd(reqBody PostAdRequest) models.Ad {
+	return models.Ad{
+		ID:           uuid.New(),
+		Title:        reqBody.Title,
+		StartAt:      reqBody.StartAt,
+		EndAt:        reqBody.EndAt,
+		Status:       models.StatusActive,
+		Conditions:   reqBody.Conditions,
+		Targeting:    reqBody.Targeting,
+		Creative:     reqBody.Creative,
+		FrequencyCap: reqBody.FrequencyCap,
+		CampaignID:   reqBody.CampaignID,
+		Budget:       reqBody.Budget,
+		Schedule:     reqBody.Schedule,
+		Priority:     reqBody.Priority,
+		Weight:       reqBody.Weight,
+	}
+}
+
+// checkCampaign returns errUnknownCampaign if the ad refers to a campaign that doesn't exist
+func checkCampaign(ctx context.Context, database persistent.Storage, campaignID *uuid.UUID) error {
+	if campaignID == nil {
+		return nil
+	}
+	_, err := database.GetCampaign(ctx, *campaignID)
+	if errors.Is(err, persistent.ErrCampaignNotFound) {
+		return errUnknownCampaign
+	}
+	return err
+}
+
 func validateRequest(reqBody PostAdRequest) error {
 	if reqBody.StartAt.After(reqBody.EndAt) {
 		return errors.New("startAt must be before endAt")
@@ -103,5 +172,76 @@ func validateRequest(reqBody PostAdRequest) error {
 	if len(reqBody.Title) > MaxTitleLength {
 		return errors.New("title too long")
 	}
+	if reqBody.Targeting != nil {
+		if len(reqBody.Conditions) > 0 {
+			return errors.New("conditions and targeting can't be used together")
+		}
+		if err := reqBody.Targeting.Validate(); err != nil {
+			return err
+		}
+	}
+	err := validateCreative(reqBody.Creative)
+	if err != nil {
+		return err
+	}
+	if reqBody.FrequencyCap != nil {
+		if reqBody.FrequencyCap.Max <= 0 {
+			return errors.New("frequency cap max must be positive")
+		}
+		if reqBody.FrequencyCap.WindowSeconds <= 0 || reqBody.FrequencyCap.Window() > MaxFrequencyCapWindow {
+			return errors.New("frequency cap window must be positive and at most 30 days")
+		}
+	}
+	if reqBody.Schedule != nil {
+		if err := reqBody.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
+	if reqBody.Priority < 0 || reqBody.Priority > MaxPriority {
+		return errors.New("priority must be between 0 and 100")
+	}
+	if reqBody.Weight < 0 || reqBody.Weight > MaxWeight {
+		return errors.New("weight must be between 0 and 1000")
+	}
+	if reqBody.Budget != nil {
+		if reqBody.Budget.ImpressionCost <= 0 || reqBody.Budget.ImpressionCost > reqBody.Budget.Total {
+			return errors.New("budget impression cost must be positive and at most the total")
+		}
+		if reqBody.Budget.Daily != 0 && (reqBody.Budget.Daily < reqBody.Budget.ImpressionCost || reqBody.Budget.Daily > reqBody.Budget.Total) {
+			return errors.New("daily budget must be between the impression cost and the total")
+		}
+	}
 	return nil
 }
+
+func validateCreative(creative models.Creative) error {
+	if len(creative.Description) > MaxDescriptionLength {
+		return errors.New("description too long")
+	}
+	if len(creative.CallToAction) > MaxCallToActionLength {
+		return errors.New("call to action too long")
+	}
+	//images are embedded in the page, so they must be served over https to avoid mixed content
+	if creative.ImageURL != "" && !validURL(creative.ImageURL, "https") {
+		return errors.New("image url must be an absolute https url of at most 2048 characters")
+	}
+	if creative.ClickURL != "" && !validURL(creative.ClickURL, "http", "https") {
+		return errors.New("click url must be an absolute http or https url of at most 2048 characters")
+	}
+	if creative.CallToAction != "" && creative.ClickURL == "" {
+		return errors.New("call to action requires a click url")
+	}
+	return nil
+}
+
+// validURL reports if raw is an absolute url with a host and one of the schemes
+func validURL(raw string, schemes ...string) bool {
+	if len(raw) > MaxURLLength {
+		return false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return slices.Contains(schemes, parsed.Scheme)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/persistent"
 	"advertise_service/internal/mock"
 	"advertise_service/internal/models"
@@ -48,3 +49,72 @@ func TestPostAd(t *testing.T) {
 		require.True(t, i >= 0, "ad not found", ad.Title)
 	}
 }
+
+func TestPostAdFailureNotCached(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	request := PostAdRequest{
+		Title:   "duplicate country",
+		StartAt: time.Now().UTC().Add(-time.Hour),
+		EndAt:   time.Now().UTC().Add(time.Hour),
+		Conditions: []models.Condition{
+			{Country: []models.Country{models.Taiwan, models.Taiwan}},
+		},
+	}
+	_, err := postAd(ctx, request)
+	require.Error(t, err)
+
+	storage := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	ads, err := storage.FindAdsWithTime(ctx, time.Now().UTC(), time.Now().UTC())
+	require.NoError(t, err)
+	require.Empty(t, ads)
+
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, 10)
+	require.NoError(t, err)
+	require.Empty(t, cached)
+}
+
+func TestPostAdCreative(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	creative := models.Creative{
+		Description:  "Half price this week",
+		ImageURL:     "https://cdn.example.com/sale.png",
+		ClickURL:     "https://example.com/sale?utm_source=dcard",
+		CallToAction: "Shop now",
+	}
+	request := PostAdRequest{
+		Title:    "sale",
+		StartAt:  time.Now().UTC().Add(-time.Hour),
+		EndAt:    time.Now().UTC().Add(time.Hour),
+		Creative: creative,
+	}
+	require.NoError(t, validateRequest(request))
+	_, err := postAd(ctx, request)
+	require.NoError(t, err)
+
+	response, err := fetchMatched(ctx, GetAdsRequest{Limit: 1, Age: 20, Gender: models.Male, Country: models.Taiwan, Platform: models.Web})
+	require.NoError(t, err)
+	require.Len(t, response.Items, 1)
+	require.Equal(t, creative.Description, response.Items[0].Description)
+	require.Equal(t, creative.ImageURL, response.Items[0].ImageURL)
+	//the landing page is only reached through the click redirect
+	require.True(t, strings.HasPrefix(response.Items[0].ClickURL, ClickPathPrefix), response.Items[0].ClickURL)
+	require.Equal(t, creative.CallToAction, response.Items[0].CallToAction)
+}
+
+func TestValidateCreative(t *testing.T) {
+	for _, invalid := range []models.Creative{
+		{Description: strings.Repeat("a", MaxDescriptionLength+1)},
+		{ImageURL: "http://example.com/image.png"},
+		{ImageURL: "javascript:alert(1)"},
+		{ImageURL: "/relative.png"},
+		{ClickURL: "ftp://example.com"},
+		{ClickURL: "https://example.com/" + strings.Repeat("a", MaxURLLength)},
+		{ClickURL: "https://example.com", CallToAction: strings.Repeat("a", MaxCallToActionLength+1)},
+		{CallToAction: "Shop now"},
+	} {
+		require.Error(t, validateCreative(invalid), invalid)
+	}
+	require.NoError(t, validateCreative(models.Creative{}))
+	require.NoError(t, validateCreative(models.Creative{ClickURL: "http://example.com", CallToAction: "Go"}))
+}
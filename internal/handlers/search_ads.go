@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+type SearchAdsResponse struct {
+	Items []models.Ad `json:"items"`
+	// Total is the amount of ads matching the filters across every page
+	Total int `json:"total"`
+}
+
+// SearchAdsHandler lists every stored ad for the admin, including paused and ended ones, straight from the database.
+// The query parameters are the filters status, title, start_from, start_to, end_from, end_to, country and platform,
+// sort with start_at, end_at or title prefixed by - for descending order, and limit and offset for pagination.
+func SearchAdsHandler(writer http.ResponseWriter, request *http.Request) {
+	search, err := parseAdSearch(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	ads, total, err := database.SearchAds(request.Context(), search)
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, SearchAdsResponse{Items: ads, Total: total})
+}
+
+func parseAdSearch(query url.Values) (persistent.AdSearch, error) {
+	search := persistent.AdSearch{
+		Status:   models.Status(query.Get("status")),
+		Title:    query.Get("title"),
+		Country:  models.Country(query.Get("country")),
+		Platform: models.Platform(query.Get("platform")),
+		Limit:    DefaultSearchLimit,
+	}
+	if search.Status != "" && !models.ValidStatus(search.Status) {
+		return persistent.AdSearch{}, errors.New("invalid status")
+	}
+	if len(search.Title) > MaxTitleLength {
+		return persistent.AdSearch{}, errors.New("title too long")
+	}
+	if search.Country != "" && !models.ValidCountry(search.Country) {
+		return persistent.AdSearch{}, errors.New("invalid country")
+	}
+	if search.Platform != "" && !models.ValidPlatform(search.Platform) {
+		return persistent.AdSearch{}, errors.New("invalid platform")
+	}
+
+	for name, bound := range map[string]*time.Time{
+		"start_from": &search.StartFrom,
+		"start_to":   &search.StartTo,
+		"end_from":   &search.EndFrom,
+		"end_to":     &search.EndTo,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return persistent.AdSearch{}, fmt.Errorf("invalid %s", name)
+		}
+		*bound = parsed.UTC()
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		search.Sort = persistent.AdSort(strings.TrimPrefix(sort, "-"))
+		search.Descending = strings.HasPrefix(sort, "-")
+		if !persistent.ValidAdSort(search.Sort) {
+			return persistent.AdSearch{}, errors.New("invalid sort")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > MaxSearchLimit {
+			return persistent.AdSearch{}, fmt.Errorf("limit must be between 1 and %d", MaxSearchLimit)
+		}
+		search.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return persistent.AdSearch{}, errors.New("invalid offset")
+		}
+		search.Offset = offset
+	}
+	return search, nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseAdSearch(t *testing.T) {
+	query, err := url.ParseQuery("status=paused&title=sale&start_from=2024-05-01T00:00:00%2B08:00&end_to=2024-06-01T00:00:00Z&country=TW&platform=ios&sort=-end_at&limit=50&offset=100")
+	require.NoError(t, err)
+	search, err := parseAdSearch(query)
+	require.NoError(t, err)
+	assert.Equal(t, persistent.AdSearch{
+		Status:     models.StatusPaused,
+		Title:      "sale",
+		StartFrom:  time.Date(2024, 4, 30, 16, 0, 0, 0, time.UTC),
+		EndTo:      time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Country:    models.Taiwan,
+		Platform:   models.Ios,
+		Sort:       persistent.SortByEndAt,
+		Descending: true,
+		Limit:      50,
+		Offset:     100,
+	}, search)
+
+	search, err = parseAdSearch(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, persistent.AdSearch{Limit: DefaultSearchLimit}, search)
+
+	for _, invalid := range []string{"status=gone", "start_to=yesterday", "country=XX", "platform=fridge", "sort=id", "sort=-", "limit=0", "limit=101", "offset=-1"} {
+		query, err := url.ParseQuery(invalid)
+		require.NoError(t, err)
+		_, err = parseAdSearch(query)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSearchAds(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+	for _, title := range []string{"spring sale", "summer sale", "winter clearance"} {
+		_, err := postAd(ctx, PostAdRequest{Title: title, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)})
+		require.NoError(t, err)
+	}
+
+	search := func(query string) (int, SearchAdsResponse) {
+		httpRequest := httptest.NewRequest(http.MethodGet, "/api/v1/admin/ad?"+query, nil).WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		SearchAdsHandler(recorder, httpRequest)
+		var response SearchAdsResponse
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		}
+		return recorder.Code, response
+	}
+	code, response := search("title=sale&sort=-title&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, response.Total)
+	require.Len(t, response.Items, 1)
+	assert.Equal(t, "summer sale", response.Items[0].Title)
+
+	//paused ads aren't served but are still listed
+	_, err := pauseAd(ctx, response.Items[0].ID)
+	require.NoError(t, err)
+	_, response = search("status=paused")
+	require.Len(t, response.Items, 1)
+	assert.Equal(t, "summer sale", response.Items[0].Title)
+
+	code, _ = search("sort=priority")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
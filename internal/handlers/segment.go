@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxSegmentUploadBytes bounds the size of an uploaded segment file
+const MaxSegmentUploadBytes = 64 << 20
+
+var errUnknownSegment = errors.New("segment doesn't exist")
+
+// PostSegmentHandler creates a segment named by the name query parameter, the body is the list of its viewers
+func PostSegmentHandler(writer http.ResponseWriter, request *http.Request) {
+	name := request.URL.Query().Get("name")
+	err := validateName(name)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	members, err := parseSegmentMembers(http.MaxBytesReader(writer, request.Body, MaxSegmentUploadBytes))
+	if err != nil {
+		writeUploadError(writer, err)
+		return
+	}
+
+	segment, err := postSegment(request.Context(), name, members)
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusCreated, segment)
+}
+
+func GetSegmentHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "segment")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database := request.Context().Value(StorageContextKey{}).(persistent.Storage)
+	segment, err := database.GetSegment(request.Context(), id)
+	if errors.Is(err, persistent.ErrSegmentNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, segment)
+}
+
+// PutSegmentHandler replaces the viewers of a segment with the uploaded list, the name query parameter optionally renames it
+func PutSegmentHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "segment")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := request.URL.Query().Get("name")
+	if name != "" {
+		if err := validateName(name); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	members, err := parseSegmentMembers(http.MaxBytesReader(writer, request.Body, MaxSegmentUploadBytes))
+	if err != nil {
+		writeUploadError(writer, err)
+		return
+	}
+
+	segment, err := putSegment(request.Context(), id, name, members)
+	if errors.Is(err, persistent.ErrSegmentNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeResource(writer, request, http.StatusOK, segment)
+}
+
+// DeleteSegmentHandler deletes a segment, ads still targeting it no longer match any viewer
+func DeleteSegmentHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := parsePathID(request, "segment")
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = deleteSegment(request.Context(), id)
+	if errors.Is(err, persistent.ErrSegmentNotFound) {
+		http.NotFound(writer, request)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// postSegment stores the members before the segment itself, so a segment that exists always has its members
+func postSegment(ctx context.Context, name string, members []string) (models.Segment, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+
+	segment := models.Segment{ID: uuid.New(), Name: name, Size: len(members), UpdatedAt: time.Now().UTC()}
+	err := cacheService.ReplaceSegment(ctx, segment.ID, members)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	err = database.InsertSegment(ctx, segment)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	return segment, nil
+}
+
+func putSegment(ctx context.Context, id uuid.UUID, name string, members []string) (models.Segment, error) {
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+
+	segment, err := database.GetSegment(ctx, id)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	if name != "" {
+		segment.Name = name
+	}
+	segment.Size = len(members)
+	segment.UpdatedAt = time.Now().UTC()
+
+	err = cacheService.ReplaceSegment(ctx, id, members)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	err = database.UpdateSegment(ctx, segment)
+	if err != nil {
+		return models.Segment{}, err
+	}
+	return segment, nil
+}
+
+func deleteSegment(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	database := ctx.Value(StorageContextKey{}).(persistent.Storage)
+	cacheService := ctx.Value(CacheContextKey{}).(cache.Service)
+
+	err := database.DeleteSegment(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = cacheService.DeleteSegment(ctx, id)
+	if err != nil {
+		// the members are only left behind in redis, ads can't target the deleted segment anymore
+		logger.Log(zap.ErrorLevel, "error deleting segment members", zap.Error(err))
+	}
+	return nil
+}
+
+// parseSegmentMembers reads the viewer ids of a segment from a csv file, taking the first column of every row,
+// a newline separated list is a csv file with a single column. Blank rows and duplicates are skipped.
+func parseSegmentMembers(reader io.Reader) ([]string, error) {
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	records.ReuseRecord = true
+
+	seen := map[string]bool{}
+	members := []string{}
+	for {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		member := strings.TrimSpace(record[0])
+		if member == "" || seen[member] {
+			continue
+		}
+		if len(member) > MaxViewerLength {
+			line, _ := records.FieldPos(0)
+			return nil, fmt.Errorf("viewer too long on line %d", line)
+		}
+		seen[member] = true
+		members = append(members, member)
+	}
+}
+
+func writeUploadError(writer http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(writer, "segment file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(writer, err.Error(), http.StatusBadRequest)
+}
+
+// checkSegments returns errUnknownSegment if the targeting of the ad refers to a segment that doesn't exist
+func checkSegments(ctx context.Context, database persistent.Storage, ad models.Ad) error {
+	for _, id := range ad.Segments() {
+		_, err := database.GetSegment(ctx, id)
+		if errors.Is(err, persistent.ErrSegmentNotFound) {
+			return fmt.Errorf("%w: %s", errUnknownSegment, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/models"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSegmentMembers(t *testing.T) {
+	members, err := parseSegmentMembers(strings.NewReader("user-1\nuser-2\n\nuser-1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2"}, members)
+
+	//only the first column of a csv file is read
+	members, err = parseSegmentMembers(strings.NewReader("user-1,2024-05-01\n\"user-2\",2024-05-02,extra\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user-1", "user-2"}, members)
+
+	_, err = parseSegmentMembers(strings.NewReader(strings.Repeat("a", MaxViewerLength+1)))
+	assert.Error(t, err)
+	_, err = parseSegmentMembers(strings.NewReader("\"unterminated\n"))
+	assert.Error(t, err)
+}
+
+func TestSegments(t *testing.T) {
+	ctx := InjectMockedResources(context.Background())
+	now := time.Now().UTC()
+
+	upload := func(method string, target string, id string, body string) *httptest.ResponseRecorder {
+		httpRequest := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+		httpRequest.SetPathValue("id", id)
+		recorder := httptest.NewRecorder()
+		switch method {
+		case http.MethodPost:
+			PostSegmentHandler(recorder, httpRequest)
+		case http.MethodPut:
+			PutSegmentHandler(recorder, httpRequest)
+		}
+		return recorder
+	}
+	recorder := upload(http.MethodPost, "/api/v1/segment?name=makeup", "", "user-1\nuser-2\n")
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	var segment models.Segment
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&segment))
+	assert.Equal(t, "makeup", segment.Name)
+	assert.Equal(t, 2, segment.Size)
+	assert.Equal(t, http.StatusBadRequest, upload(http.MethodPost, "/api/v1/segment", "", "user-1").Code)
+
+	//retarget the makeup board visitors in TW
+	conditions := []models.Condition{{Country: []models.Country{models.Taiwan}, Segments: []uuid.UUID{segment.ID}}}
+	_, err := postAd(ctx, PostAdRequest{Title: "retargeted", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: conditions})
+	require.NoError(t, err)
+	unknown := []models.Condition{{Segments: []uuid.UUID{uuid.New()}}}
+	_, err = postAd(ctx, PostAdRequest{Title: "unknown", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: unknown})
+	assert.ErrorIs(t, err, errUnknownSegment)
+
+	matches := func(viewer string) bool {
+		response, err := fetchMatched(ctx, GetAdsRequest{Limit: 10, Viewer: viewer, Age: 30, Gender: models.Female, Country: models.Taiwan, Platform: models.Web})
+		require.NoError(t, err)
+		return len(response.Items) == 1
+	}
+	assert.True(t, matches("user-1"))
+	assert.False(t, matches("user-3"))
+	//anonymous viewers are in no segment
+	assert.False(t, matches(""))
+
+	//uploading again replaces the members
+	recorder = upload(http.MethodPut, "/api/v1/segment/"+segment.ID.String(), segment.ID.String(), "user-3\n")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.False(t, matches("user-1"))
+	assert.True(t, matches("user-3"))
+	assert.Equal(t, http.StatusNotFound, upload(http.MethodPut, "/", uuid.NewString(), "user-3\n").Code)
+
+	require.NoError(t, deleteSegment(ctx, segment.ID))
+	assert.False(t, matches("user-3"))
+	_, err = ctx.Value(StorageContextKey{}).(persistent.Storage).GetSegment(ctx, segment.ID)
+	assert.ErrorIs(t, err, persistent.ErrSegmentNotFound)
+	assert.ErrorIs(t, deleteSegment(ctx, segment.ID), persistent.ErrSegmentNotFound)
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"advertise_service/internal/handlers"
+	"advertise_service/internal/infra"
+	"advertise_service/internal/infra/logging"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const importUsage = "usage: import [-format csv|jsonl] <file>"
+
+// ImportCommand runs `import <file>`, creating every ad of a csv or json lines file like the bulk import endpoint,
+// and writes the created ads or the invalid rows to out. The format is taken from the file extension unless -format is given.
+func ImportCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	formatName := flags.String("format", "", "")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+	path := flags.Arg(0)
+	if *formatName == "" {
+		*formatName = filepath.Ext(path)
+	}
+	format, err := handlers.ParseImportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	config := infra.LoadDatabaseConfig()
+	if config.RedisURI == "" {
+		return errors.New("missing REDIS_URI")
+	}
+	storage, cacheService, closeConnections := infra.CommandSetup(config)
+	defer closeConnections()
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(context.Background(), handlers.StorageContextKey{}, storage)
+	ctx = context.WithValue(ctx, handlers.CacheContextKey{}, cacheService)
+	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, logger)
+
+	response, err := handlers.ImportAds(ctx, file, format)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range response.Errors {
+		fmt.Fprintf(out, "row %d: %s\n", rowErr.Row, rowErr.Error)
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("%d invalid rows, no ads were imported", len(response.Errors))
+	}
+	for _, id := range response.AdIDs {
+		fmt.Fprintln(out, "imported", id)
+	}
+	return nil
+}
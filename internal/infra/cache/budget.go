@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// dailySpentTTL keeps the daily spend counters a bit longer than a day, so a counter outlives clock skew between replicas
+const dailySpentTTL = 48 * time.Hour
+
+// chargeScript adds the impression cost to the spend counters of an ad, only if the spend stays within both limits.
+// KEYS[1] is the total spend, KEYS[2] the spend of the day.
+// ARGV is the impression cost, the paced total limit, the daily limit (0 for none) and the expiry of both counters.
+// Returns 1 when the ad was charged, 0 when its budget is exhausted.
+var chargeScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+local total = tonumber(redis.call('GET', KEYS[1]) or '0')
+if total + cost > tonumber(ARGV[2]) then
+	return 0
+end
+local dailyLimit = tonumber(ARGV[3])
+local daily = tonumber(redis.call('GET', KEYS[2]) or '0')
+if dailyLimit > 0 and daily + cost > dailyLimit then
+	return 0
+end
+redis.call('INCRBY', KEYS[1], cost)
+redis.call('EXPIREAT', KEYS[1], ARGV[4])
+redis.call('INCRBY', KEYS[2], cost)
+redis.call('EXPIRE', KEYS[2], ARGV[5])
+return 1
+`)
+
+// budgetKey holds the total spend of an ad
+func budgetKey(adID uuid.UUID) string {
+	return budgetKeyPrefix + adID.String()
+}
+
+// dailyBudgetKey holds the spend of an ad within the UTC day of now
+func dailyBudgetKey(adID uuid.UUID, now time.Time) string {
+	return budgetKey(adID) + ":" + now.UTC().Format(time.DateOnly)
+}
+
+func chargeBudgets(ctx context.Context, client *redis.Client, ads []models.Ad, now time.Time) (map[uuid.UUID]bool, error) {
+	charged := map[uuid.UUID]bool{}
+	var budgeted []models.Ad
+	for _, ad := range ads {
+		if ad.Budget != nil {
+			budgeted = append(budgeted, ad)
+		}
+	}
+	if len(budgeted) == 0 {
+		return charged, nil
+	}
+
+	results := make([]*redis.Cmd, len(budgeted))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, ad := range budgeted {
+			keys := []string{budgetKey(ad.ID), dailyBudgetKey(ad.ID, now)}
+			//the total spend is kept a day after the ad ends, in case it is extended
+			results[i] = chargeScript.Eval(ctx, pipe, keys, ad.Budget.ImpressionCost, ad.PacedLimit(now), ad.Budget.Daily,
+				ad.EndAt.Add(24*time.Hour).Unix(), int64(dailySpentTTL.Seconds()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ad := range budgeted {
+		ok, err := results[i].Int()
+		if err != nil {
+			return nil, err
+		}
+		charged[ad.ID] = ok == 1
+	}
+	return charged, nil
+}
@@ -2,21 +2,17 @@ package cache
 
 import (
 	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
 	"advertise_service/internal/models"
-	"bytes"
-	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"log"
-	"math"
 	"slices"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -25,13 +21,16 @@ func isValid(lastUpdate time.Time) bool {
 }
 
 func storeActiveAd(ctx context.Context, rdb *redis.Client, ad models.Ad) error {
-	jsonStr := bytes.Buffer{}
-	err := json.NewEncoder(&jsonStr).Encode(ad)
+	jsonStr, err := json.Marshal(ad)
 	if err != nil {
 		return err
 	}
 
-	err = rdb.ZAdd(ctx, adsKey, redis.Z{Member: jsonStr.String(), Score: float64(ad.EndAt.Unix())}).Err()
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, adsKey, redis.Z{Member: string(jsonStr), Score: float64(ad.EndAt.Unix())})
+		pipe.Incr(ctx, versionKey)
+		return nil
+	})
 	if err != nil {
 		log.Printf("failed to cache active ad: %v", err)
 		return err
@@ -39,7 +38,88 @@ func storeActiveAd(ctx context.Context, rdb *redis.Client, ad models.Ad) error {
 	return nil
 }
 
-func updateCache(ctx context.Context, rdb *redis.Client, ads []models.Ad) (int, error) {
+// storeActiveAds adds the ads to the cache in one transaction, the entries already cached for the same ads are replaced
+func storeActiveAds(ctx context.Context, rdb *redis.Client, ads []models.Ad) error {
+	if len(ads) == 0 {
+		return nil
+	}
+	cached, err := getCachedMembers(ctx, rdb)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]redis.Z, 0, len(ads))
+	var outdated []interface{}
+	for _, ad := range ads {
+		jsonStr, err := json.Marshal(ad)
+		if err != nil {
+			return err
+		}
+		for _, member := range cached[ad.ID] {
+			outdated = append(outdated, member)
+		}
+		entries = append(entries, redis.Z{Member: string(jsonStr), Score: float64(ad.EndAt.Unix())})
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(outdated) != 0 {
+			pipe.ZRem(ctx, adsKey, outdated...)
+		}
+		pipe.ZAdd(ctx, adsKey, entries...)
+		pipe.Incr(ctx, versionKey)
+		return nil
+	})
+	return err
+}
+
+func removeActiveAd(ctx context.Context, rdb *redis.Client, id uuid.UUID) error {
+	//members are the encoded ads, so we have to look up the entries that belong to the id first
+	members, err := getCachedMembers(ctx, rdb)
+	if err != nil {
+		return err
+	}
+
+	var matched []interface{}
+	for _, member := range members[id] {
+		matched = append(matched, member)
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, adsKey, matched...)
+		pipe.Incr(ctx, versionKey)
+		return nil
+	})
+	return err
+}
+
+// getCachedMembers returns the raw sorted set members grouped by ad id
+func getCachedMembers(ctx context.Context, rdb redis.Cmdable) (map[uuid.UUID][]string, error) {
+	members, err := rdb.ZRange(ctx, adsKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[uuid.UUID][]string, len(members))
+	for _, member := range members {
+		entry := struct {
+			ID uuid.UUID `json:"id"`
+		}{}
+		if err := json.Unmarshal([]byte(member), &entry); err != nil {
+			return nil, err
+		}
+		grouped[entry.ID] = append(grouped[entry.ID], member)
+	}
+	return grouped, nil
+}
+
+// maxUpdateAttempts bounds how often updateCache recomputes the changes when the cached ads are written at the same time
+const maxUpdateAttempts = 3
+
+// updateCache replaces the cached active ads with the given ones, which were loaded when the cache was at version since.
+// Ads that aren't given are removed from the cache only if it didn't change since, so ads written while the given ones were loaded are kept.
+func updateCache(ctx context.Context, rdb *redis.Client, ads []models.Ad, since int64) (int, error) {
 	allowStartBefore := time.Now().UTC().Add(Interval).Add(Tolerance)
 	ads = slices.DeleteFunc(ads, func(ad models.Ad) bool {
 		return !ad.StartAt.Before(allowStartBefore)
@@ -55,49 +135,98 @@ func updateCache(ctx context.Context, rdb *redis.Client, ads []models.Ad) (int,
 	defer releaseUpdateLock(ctx, rdb, lockId)
 
 	//remove ads that are expired
-	removed, err := rdb.ZRemRangeByScore(ctx, adsKey, "-inf", strconv.FormatInt(time.Now().UTC().Unix()/1000, 10)).Result()
+	removed, err := rdb.ZRemRangeByScore(ctx, adsKey, "-inf", strconv.FormatInt(time.Now().UTC().Unix(), 10)).Result()
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "failed to remove expired ads", zap.Error(err))
+		return 0, err
+	}
 	logger.Log(zap.DebugLevel, "removed expired ads", zap.Int64("amount", removed))
 
-	//get the largest start time in the cache
-	largestStartTime, err := getLargestStartInCache(ctx, rdb)
+	//single ads are written without the lock, so the version is watched to notice them while the changes are computed
+	written := 0
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err = rdb.Watch(ctx, func(tx *redis.Tx) error {
+			written, err = replaceCachedAds(ctx, tx, ads, since, removed != 0)
+			return err
+		}, versionKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
 	if err != nil {
+		logger.Log(zap.ErrorLevel, "failed to cache active ads", zap.Error(err))
 		return 0, err
 	}
 
-	logger.Log(zap.DebugLevel, "largest start time in cache", zap.Time("largest_start_time", largestStartTime))
-	//prepare active ads
+	//update last update time
+	err = rdb.Set(ctx, lastUpdateKey, time.Now().UTC(), time.Hour*2).Err()
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "fail to update time", zap.Error(err))
+		return 0, nil
+	}
+	return written, nil
+}
+
+// replaceCachedAds writes the changed ads in one transaction, and removes the cached ads that aren't given if the version is still since
+func replaceCachedAds(ctx context.Context, tx *redis.Tx, ads []models.Ad, since int64, changed bool) (int, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	version, err := getVersion(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	cached, err := getCachedMembers(ctx, tx)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "failed to read cached ads", zap.Error(err))
+		return 0, err
+	}
+
+	//prepare active ads, entries of ads that changed since they were cached are replaced
+	//and the entries of ads that are no longer active are removed, unless they might have been cached after the ads were loaded
 	var entries []redis.Z
-	for _, ad := range ads {
-		if !ad.StartAt.After(largestStartTime) {
-			logger.Log(zap.DebugLevel, "skipping ad that is already in cache", zap.String("ad_id", ad.ID.String()), zap.Time("start_at", ad.StartAt))
-			continue
-		} else {
-			logger.Log(zap.DebugLevel, "not skipping", zap.Time("start_at", ad.StartAt))
+	var outdated []interface{}
+	if version == since {
+		fresh := make(map[uuid.UUID]bool, len(ads))
+		for _, ad := range ads {
+			fresh[ad.ID] = true
+		}
+		for id, members := range cached {
+			if !fresh[id] {
+				for _, member := range members {
+					outdated = append(outdated, member)
+				}
+			}
 		}
+	}
+	for _, ad := range ads {
 		jsonStr, err := json.Marshal(ad)
 		if err != nil {
 			return 0, err
 		}
-		entries = append(entries, redis.Z{Member: jsonStr, Score: float64(ad.EndAt.Unix() / 1000)})
-	}
-
-	if len(entries) != 0 {
-		//store new ads
-		count, err := rdb.ZAdd(ctx, adsKey, entries...).Result()
-
-		if err != nil || count != int64(len(entries)) {
-			logger.Log(zap.ErrorLevel, "failed to cache active ads", zap.Error(err), zap.Int64("insert_count", count), zap.String("entries", fmt.Sprint(entries)))
-			return 0, err
+		members := cached[ad.ID]
+		if len(members) == 1 && members[0] == string(jsonStr) {
+			logger.Log(zap.DebugLevel, "skipping ad that is already in cache", zap.String("ad_id", ad.ID.String()))
+			continue
 		}
+		for _, member := range members {
+			outdated = append(outdated, member)
+		}
+		entries = append(entries, redis.Z{Member: string(jsonStr), Score: float64(ad.EndAt.Unix())})
 	}
 
-	//update last update time
-	err = rdb.Set(ctx, lastUpdateKey, time.Now().UTC(), time.Hour*2).Err()
-	if err != nil {
-		logger.Log(zap.ErrorLevel, "fail to update time", zap.Error(err))
+	if len(entries) == 0 && len(outdated) == 0 && !changed {
 		return 0, nil
 	}
-	return len(entries), nil
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(outdated) != 0 {
+			pipe.ZRem(ctx, adsKey, outdated...)
+		}
+		if len(entries) != 0 {
+			pipe.ZAdd(ctx, adsKey, entries...)
+		}
+		pipe.Incr(ctx, versionKey)
+		return nil
+	})
+	return len(entries), err
 }
 
 // to make sure only one client updates the whole list, we'll need to implement a simple lock
@@ -109,14 +238,16 @@ func tryAcquireUpdateLock(ctx context.Context, client *redis.Client, lockId stri
 		return err
 	}
 	if !success {
-		return errors.New("lock is already acquired by someone else")
+		metrics.UpdateLockContended()
+		return ErrUpdateLocked
 	}
 
+	metrics.UpdateLockAcquired()
 	return nil
 }
 
 func releaseUpdateLock(ctx context.Context, client *redis.Client, lockId string) error {
-	lockIdInCache, err := client.Get(ctx, "active_ads_lock").Result()
+	lockIdInCache, err := client.Get(ctx, lockKey).Result()
 	if err != nil {
 		return err
 	}
@@ -140,37 +271,55 @@ func getLastUpdate(ctx context.Context, client *redis.Client) (time.Time, error)
 	return time.Parse(time.RFC3339Nano, result)
 }
 
-func getAdsFromRedis(ctx context.Context, client *redis.Client, skip int, count int) ([]models.Ad, error) {
-	adStrings, err := client.ZRange(ctx, adsKey, int64(skip), int64(skip+count)).Result()
-	if err != nil {
-		return []models.Ad{}, err
-	}
+// maxBatchSize limits how many members are read from redis in a single round trip
+const maxBatchSize = 1000
 
-	ads := make([]models.Ad, 0, len(adStrings))
-	for _, adStr := range adStrings {
-		ad := models.Ad{}
-		err := json.NewDecoder(strings.NewReader(adStr)).Decode(&ad)
-		if err != nil {
-			return ads, err
-		}
-		ads = append(ads, ad)
+// getVersion returns the number of changes made to the active ads, 0 if they were never changed
+func getVersion(ctx context.Context, client redis.Cmdable) (int64, error) {
+	version, err := client.Get(ctx, versionKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
 	}
-	return ads, nil
+	return version, err
 }
 
-func getLargestStartInCache(ctx context.Context, rdb *redis.Client) (time.Time, error) {
-	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
-	inCacheAds, err := getAdsFromRedis(ctx, rdb, 0, math.MaxInt)
-	if err != nil {
-		logger.Log(zap.ErrorLevel, "failed to get the largest start time in the cache", zap.Error(err))
-		return time.Time{}, err
+// getAdsAfter reads up to count ads that come after the cursor in the sorted set
+func getAdsAfter(ctx context.Context, client *redis.Client, after Cursor, count int) ([]models.Ad, error) {
+	ads := make([]models.Ad, 0, min(count, maxBatchSize))
+	minScore := "-inf"
+	if !after.IsZero() {
+		//ads sharing the end time of the cursor may or may not come after it, they are filtered below
+		minScore = strconv.FormatInt(after.EndAt, 10)
 	}
 
-	if len(inCacheAds) != 0 {
-		return slices.MaxFunc(inCacheAds, func(i, j models.Ad) int {
-			return cmp.Compare(i.StartAt.Unix(), j.StartAt.Unix())
-		}).StartAt, nil
-	} else {
-		return time.Unix(0, 0), nil
+	batchSize := int64(min(count, maxBatchSize))
+	offset := int64(0)
+	for len(ads) < count {
+		adStrings, err := client.ZRangeByScore(ctx, adsKey, &redis.ZRangeBy{
+			Min:    minScore,
+			Max:    "+inf",
+			Offset: offset,
+			Count:  batchSize,
+		}).Result()
+		if err != nil {
+			return []models.Ad{}, err
+		}
+
+		for _, adStr := range adStrings {
+			ad := models.Ad{}
+			err := json.Unmarshal([]byte(adStr), &ad)
+			if err != nil {
+				return ads, err
+			}
+			if after.Before(ad) && len(ads) < count {
+				ads = append(ads, ad)
+			}
+		}
+
+		if int64(len(adStrings)) < batchSize {
+			break
+		}
+		offset += int64(len(adStrings))
 	}
+	return ads, nil
 }
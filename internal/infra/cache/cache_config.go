@@ -6,6 +6,16 @@ var (
 	lastUpdateKey = "last_update"
 	adsKey        = "active_ads"
 	lockKey       = "active_ads_lock"
+	// versionKey is incremented whenever the active ads change, it's never reset so a version is never reused
+	versionKey = "active_ads_version"
+	// frequencyKeyPrefix prefixes the per viewer impression counters of frequency capped ads
+	frequencyKeyPrefix = "active_ads_frequency:"
+	// budgetKeyPrefix prefixes the spend counters of ads with a budget, they aren't cleared with the cache
+	budgetKeyPrefix = "ad_budget_spent:"
+	// segmentKeyPrefix prefixes the member sets of uploaded segments, which only live in the cache and aren't cleared with it
+	segmentKeyPrefix = "segment_members:"
+	// clickKeyPrefix prefixes the impressions whose click was recorded, they expire with the click token of the impression
+	clickKeyPrefix = "recorded_clicks:"
 
 	// Interval is the interval to check if the cache is still valid, we update the cache when it's not valid
 	// also we insert ads whose (start time)  < now + (Interval + Tolerance) in to cache
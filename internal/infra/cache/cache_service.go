@@ -4,28 +4,74 @@ import (
 	"advertise_service/internal/infra/logging"
 	"advertise_service/internal/models"
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
-	"slices"
 	"testing"
 	"time"
 )
 
+// ErrUpdateLocked is returned by Update when another client is already updating the cache
+var ErrUpdateLocked = errors.New("lock is already acquired by someone else")
+
 type Service interface {
 	// CheckCacheValid checks if the cache is updated within an hour
 	CheckCacheValid(ctx context.Context) (bool, error)
-	// GetActiveAds retrieves active ads with params skip and count in a sorted list.
-	GetActiveAds(ctx context.Context, skip int, count int) ([]models.Ad, error)
+	// LastUpdate returns the time of the last successful Update, or the zero time if the cache was never updated
+	LastUpdate(ctx context.Context) (time.Time, error)
+	// GetActiveAds retrieves up to count cached ads that come after the cursor, sorted by end time.
+	// The result may contain ads that haven't started yet, callers should check Ad.IsActive.
+	GetActiveAds(ctx context.Context, after Cursor, count int) ([]models.Ad, error)
 
 	// WriteActiveAd stores an active ad into the cache, used when the create ad is already active.
 	WriteActiveAd(ctx context.Context, ad models.Ad) error
 
-	// Update updates lastUpdate time, clears expired ads, and inserts new multiple active ads into cache.
-	// NOTICE: This function only writes ad that has a start time greater than the largest start time in the cache
-	Update(ctx context.Context, ad []models.Ad) (int, error)
+	// WriteActiveAds stores many active ads into the cache at once, replacing the cached entries of the same ads.
+	// Unlike Update it neither takes the update lock nor marks the cache as refreshed.
+	WriteActiveAds(ctx context.Context, ads []models.Ad) error
+
+	// RemoveActiveAd removes an ad from the cache, used when the ad is updated or deleted.
+	// Removing an ad that isn't cached is not an error.
+	RemoveActiveAd(ctx context.Context, id uuid.UUID) error
+
+	// Update updates lastUpdate time and replaces the cached active ads with the given ones, which were loaded when the Version was since.
+	// Cached ads that aren't given are removed, unless the cache changed since, as the ads written meanwhile are missing from the given ones.
+	// Ads that are already cached are replaced if they changed, and skipped otherwise, the amount of written ads is returned.
+	// Returns ErrUpdateLocked if someone else is updating the cache at the same time.
+	Update(ctx context.Context, ad []models.Ad, since int64) (int, error)
+
+	// Version returns a number that changes whenever the cached active ads change,
+	// so derived data such as the targeting index can be rebuilt only when needed.
+	Version(ctx context.Context) (int64, error)
+
+	// GetImpressionCounts returns how many times the viewer was shown each ad within the window of its frequency cap.
+	// Ads the viewer hasn't seen are left out.
+	GetImpressionCounts(ctx context.Context, viewer string, adIDs []uuid.UUID) (map[uuid.UUID]int, error)
+
+	// IncrImpressions counts an impression of each frequency capped ad for the viewer, ads without a cap are ignored.
+	IncrImpressions(ctx context.Context, viewer string, ads []models.Ad) error
+
+	// ChargeBudgets charges the impression cost of each ad with a budget, as long as its spend stays within
+	// the paced limit at now and the daily limit. Every budgeted ad is reported, false meaning its budget is exhausted for now.
+	// Ads without a budget are ignored.
+	ChargeBudgets(ctx context.Context, ads []models.Ad, now time.Time) (map[uuid.UUID]bool, error)
+
+	// ClaimClick reports if this is the first click of the impression, so replaying a click token isn't counted again.
+	// The impression is remembered until expiresAt, when its click token expires.
+	ClaimClick(ctx context.Context, impressionID uuid.UUID, expiresAt time.Time) (bool, error)
+
+	// ReplaceSegment atomically replaces the members of a segment, an empty list removes every member.
+	ReplaceSegment(ctx context.Context, id uuid.UUID, members []string) error
+	// DeleteSegment removes the members of a segment, deleting a segment without members is not an error.
+	DeleteSegment(ctx context.Context, id uuid.UUID) error
+	// SegmentMemberships returns the segments the viewer is a member of, out of the given ones.
+	SegmentMemberships(ctx context.Context, viewer string, segments []uuid.UUID) ([]uuid.UUID, error)
+
+	// Ping checks that the cache is reachable
+	Ping(ctx context.Context) error
 
 	// Clear clears the cache, useful for testing
 	Clear(ctx context.Context) error
@@ -38,6 +84,13 @@ type redisCacheService struct {
 func NewRedisCacheService(inner *redis.Client) Service {
 	inner.Del(context.Background(), lastUpdateKey)
 	inner.Del(context.Background(), adsKey)
+	inner.Incr(context.Background(), versionKey)
+	return redisCacheService{inner: inner}
+}
+
+// AttachRedisCacheService uses the cache as the servers left it, for commands running next to them.
+// Unlike NewRedisCacheService it doesn't reset the cache.
+func AttachRedisCacheService(inner *redis.Client) Service {
 	return redisCacheService{inner: inner}
 }
 
@@ -50,35 +103,80 @@ func (r redisCacheService) CheckCacheValid(ctx context.Context) (bool, error) {
 	return isValid(t), nil
 }
 
-func (r redisCacheService) GetActiveAds(ctx context.Context, skip int, count int) ([]models.Ad, error) {
-	ads, err := getAdsFromRedis(ctx, r.inner, skip, count)
-	if err != nil {
-		return []models.Ad{}, err
-	}
-	//may contain ads that start later, so we need to filter them out.
-	now := time.Now()
-	ads = slices.DeleteFunc(ads, func(ad models.Ad) bool {
-		return ad.StartAt.After(now)
-	})
-	return ads, nil
+func (r redisCacheService) Ping(ctx context.Context) error {
+	return r.inner.Ping(ctx).Err()
+}
+
+func (r redisCacheService) LastUpdate(ctx context.Context) (time.Time, error) {
+	return getLastUpdate(ctx, r.inner)
+}
+
+func (r redisCacheService) GetActiveAds(ctx context.Context, after Cursor, count int) ([]models.Ad, error) {
+	return getAdsAfter(ctx, r.inner, after, count)
 }
 
 func (r redisCacheService) WriteActiveAd(ctx context.Context, ad models.Ad) error {
 	return storeActiveAd(ctx, r.inner, ad)
 }
 
+func (r redisCacheService) WriteActiveAds(ctx context.Context, ads []models.Ad) error {
+	return storeActiveAds(ctx, r.inner, ads)
+}
+
+func (r redisCacheService) RemoveActiveAd(ctx context.Context, id uuid.UUID) error {
+	return removeActiveAd(ctx, r.inner, id)
+}
+
+func (r redisCacheService) Version(ctx context.Context) (int64, error) {
+	return getVersion(ctx, r.inner)
+}
+
+func (r redisCacheService) GetImpressionCounts(ctx context.Context, viewer string, adIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	return getImpressionCounts(ctx, r.inner, viewer, adIDs)
+}
+
+func (r redisCacheService) IncrImpressions(ctx context.Context, viewer string, ads []models.Ad) error {
+	return incrImpressions(ctx, r.inner, viewer, ads)
+}
+
+func (r redisCacheService) ChargeBudgets(ctx context.Context, ads []models.Ad, now time.Time) (map[uuid.UUID]bool, error) {
+	return chargeBudgets(ctx, r.inner, ads, now)
+}
+
+func (r redisCacheService) ClaimClick(ctx context.Context, impressionID uuid.UUID, expiresAt time.Time) (bool, error) {
+	return claimClick(ctx, r.inner, impressionID, expiresAt)
+}
+
+func (r redisCacheService) ReplaceSegment(ctx context.Context, id uuid.UUID, members []string) error {
+	return replaceSegment(ctx, r.inner, id, members)
+}
+
+func (r redisCacheService) DeleteSegment(ctx context.Context, id uuid.UUID) error {
+	return deleteSegment(ctx, r.inner, id)
+}
+
+func (r redisCacheService) SegmentMemberships(ctx context.Context, viewer string, segments []uuid.UUID) ([]uuid.UUID, error) {
+	return segmentMemberships(ctx, r.inner, viewer, segments)
+}
+
 func (r redisCacheService) Clear(ctx context.Context) error {
-	return r.inner.Del(ctx, lastUpdateKey, adsKey).Err()
+	_, err := r.inner.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, lastUpdateKey, adsKey)
+		pipe.Incr(ctx, versionKey)
+		return nil
+	})
+	return err
 }
 
-func (r redisCacheService) Update(ctx context.Context, ads []models.Ad) (int, error) {
-	return updateCache(ctx, r.inner, ads)
+func (r redisCacheService) Update(ctx context.Context, ads []models.Ad, since int64) (int, error) {
+	return updateCache(ctx, r.inner, ads, since)
 }
 
 func TestCacheService(t *testing.T, service Service) {
 	logger, _ := zap.NewDevelopment()
 	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
 	require.NoError(t, service.Clear(ctx))
+	require.NoError(t, service.Ping(ctx))
 
 	valid, err := service.CheckCacheValid(context.Background())
 	require.NoError(t, err)
@@ -100,10 +198,15 @@ func TestCacheService(t *testing.T, service Service) {
 				},
 			},
 		}
-		err := service.WriteActiveAd(ctx, ad)
+		version, err := service.Version(ctx)
+		require.NoError(t, err)
+		err = service.WriteActiveAd(ctx, ad)
 		require.NoError(t, err)
+		newVersion, err := service.Version(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, version, newVersion)
 
-		activeAds, err := service.GetActiveAds(ctx, 0, 3)
+		activeAds, err := service.GetActiveAds(ctx, Cursor{}, 3)
 		if err != nil {
 			return
 		}
@@ -113,6 +216,36 @@ func TestCacheService(t *testing.T, service Service) {
 	})
 	require.NoError(t, service.Clear(ctx))
 
+	t.Run("RemoveActiveAd", func(t *testing.T) {
+		ads := []models.Ad{
+			{
+				ID:      uuid.New(),
+				Title:   "title1",
+				StartAt: time.Now().UTC().Add(-time.Hour),
+				EndAt:   time.Now().UTC().Add(time.Hour),
+			},
+			{
+				ID:      uuid.New(),
+				Title:   "title2",
+				StartAt: time.Now().UTC().Add(-time.Hour),
+				EndAt:   time.Now().UTC().Add(2 * time.Hour),
+			},
+		}
+		for _, ad := range ads {
+			require.NoError(t, service.WriteActiveAd(ctx, ad))
+		}
+
+		require.NoError(t, service.RemoveActiveAd(ctx, ads[0].ID))
+		//removing an ad that isn't cached is a no-op
+		require.NoError(t, service.RemoveActiveAd(ctx, uuid.New()))
+
+		activeAds, err := service.GetActiveAds(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		require.Len(t, activeAds, 1)
+		assert.Equal(t, ads[1].ID, activeAds[0].ID)
+	})
+	require.NoError(t, service.Clear(ctx))
+
 	t.Run("Update", func(t *testing.T) {
 		assert.NoError(t, err)
 		//write many
@@ -146,15 +279,20 @@ func TestCacheService(t *testing.T, service Service) {
 			},
 		}
 
-		writeCount, err := service.Update(ctx, ads)
+		version, err := service.Version(ctx)
+		require.NoError(t, err)
+		writeCount, err := service.Update(ctx, ads, version)
 		require.NoError(t, err)
 		assert.Equal(t, 2, writeCount)
 
 		valid, err = service.CheckCacheValid(ctx)
 		require.NoError(t, err)
 		assert.True(t, valid)
+		lastUpdate, err := service.LastUpdate(ctx)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), lastUpdate, time.Minute)
 
-		activeAds, err := service.GetActiveAds(ctx, 0, 3)
+		activeAds, err := service.GetActiveAds(ctx, Cursor{}, 3)
 		if err != nil {
 			return
 		}
@@ -169,8 +307,206 @@ func TestCacheService(t *testing.T, service Service) {
 		assert.Equal(t, activeAds[1].Conditions[0].AgeEnd, 30)
 
 		//write second time
-		writeCount, err = service.Update(ctx, ads)
+		version, err = service.Version(ctx)
+		require.NoError(t, err)
+		writeCount, err = service.Update(ctx, ads, version)
+		assert.Equal(t, 0, writeCount)
+		//nothing changed
+		newVersion, err := service.Version(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, version, newVersion)
+
+		//changed ads replace their cached entry
+		ads[1].Title = "title2 changed"
+		writeCount, err = service.Update(ctx, ads, newVersion)
+		require.NoError(t, err)
+		assert.Equal(t, 1, writeCount)
+		activeAds, err = service.GetActiveAds(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		require.Len(t, activeAds, 2)
+		assert.Equal(t, "title2 changed", activeAds[0].Title)
+
+		//continue after the first ad
+		activeAds, err = service.GetActiveAds(ctx, CursorOf(activeAds[0]), 3)
+		require.NoError(t, err)
+		require.Len(t, activeAds, 1)
+		assert.Equal(t, ads[0].ID, activeAds[0].ID)
+
+		//ads missing from the update are no longer active, such as an ad whose removal from the cache failed
+		version, err = service.Version(ctx)
+		require.NoError(t, err)
+		writeCount, err = service.Update(ctx, ads[:1], version)
+		require.NoError(t, err)
 		assert.Equal(t, 0, writeCount)
+		activeAds, err = service.GetActiveAds(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		require.Len(t, activeAds, 1)
+		assert.Equal(t, ads[0].ID, activeAds[0].ID)
+
+		//an ad written after the given ads were loaded is kept, it's removed by the next refresh if it's no longer active
+		version, err = service.Version(ctx)
+		require.NoError(t, err)
+		require.NoError(t, service.WriteActiveAd(ctx, ads[1]))
+		_, err = service.Update(ctx, ads[:1], version)
+		require.NoError(t, err)
+		activeAds, err = service.GetActiveAds(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		assert.Len(t, activeAds, 2)
 	})
 
+	t.Run("WriteActiveAds", func(t *testing.T) {
+		require.NoError(t, service.Clear(ctx))
+		now := time.Now().UTC()
+		cached := models.Ad{ID: uuid.New(), Title: "cached", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+		require.NoError(t, service.WriteActiveAd(ctx, cached))
+		version, err := service.Version(ctx)
+		require.NoError(t, err)
+
+		cached.Title = "cached changed"
+		imported := models.Ad{ID: uuid.New(), Title: "imported", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)}
+		require.NoError(t, service.WriteActiveAds(ctx, []models.Ad{cached, imported}))
+		newVersion, err := service.Version(ctx)
+		require.NoError(t, err)
+		assert.NotEqual(t, version, newVersion)
+
+		activeAds, err := service.GetActiveAds(ctx, Cursor{}, 3)
+		require.NoError(t, err)
+		require.Len(t, activeAds, 2)
+		assert.Equal(t, "cached changed", activeAds[0].Title)
+		assert.Equal(t, imported.ID, activeAds[1].ID)
+		//the cache isn't marked as refreshed
+		lastUpdate, err := service.LastUpdate(ctx)
+		require.NoError(t, err)
+		assert.True(t, lastUpdate.IsZero())
+	})
+
+	t.Run("Schedule", func(t *testing.T) {
+		require.NoError(t, service.Clear(ctx))
+		now := time.Now().UTC()
+		scheduled := models.Ad{
+			ID:      uuid.New(),
+			Title:   "scheduled",
+			StartAt: now.Add(-time.Hour),
+			EndAt:   now.Add(time.Hour),
+			Schedule: &models.Schedule{
+				Timezone: "Asia/Taipei",
+				Windows:  []models.ScheduleWindow{{Days: []time.Weekday{time.Saturday, time.Sunday}, Start: "11:00", End: "14:00"}},
+			},
+		}
+		require.NoError(t, service.WriteActiveAd(ctx, scheduled))
+		cached, err := service.GetActiveAds(ctx, Cursor{}, 1)
+		require.NoError(t, err)
+		require.Len(t, cached, 1)
+		assert.Equal(t, scheduled.Schedule, cached[0].Schedule)
+	})
+
+	t.Run("FrequencyCap", func(t *testing.T) {
+		capped := models.Ad{ID: uuid.New(), FrequencyCap: &models.FrequencyCap{Max: 2, WindowSeconds: 60}}
+		uncapped := models.Ad{ID: uuid.New()}
+		viewer := uuid.New().String()
+
+		counts, err := service.GetImpressionCounts(ctx, viewer, []uuid.UUID{capped.ID, uncapped.ID})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+
+		require.NoError(t, service.IncrImpressions(ctx, viewer, []models.Ad{capped, uncapped}))
+		require.NoError(t, service.IncrImpressions(ctx, viewer, []models.Ad{capped}))
+		counts, err = service.GetImpressionCounts(ctx, viewer, []uuid.UUID{capped.ID, uncapped.ID})
+		require.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]int{capped.ID: 2}, counts)
+
+		//other viewers are counted separately
+		counts, err = service.GetImpressionCounts(ctx, uuid.New().String(), []uuid.UUID{capped.ID})
+		require.NoError(t, err)
+		assert.Empty(t, counts)
+	})
+
+	t.Run("ClaimClick", func(t *testing.T) {
+		impression := uuid.New()
+		expiresAt := time.Now().Add(time.Minute)
+		claimed, err := service.ClaimClick(ctx, impression, expiresAt)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, err = service.ClaimClick(ctx, impression, expiresAt)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+
+		claimed, err = service.ClaimClick(ctx, uuid.New(), expiresAt)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		now := time.Now().UTC()
+		budgeted := models.Ad{
+			ID:      uuid.New(),
+			StartAt: now.Add(-time.Hour),
+			EndAt:   now.Add(time.Hour),
+			Budget:  &models.Budget{Total: 30, ImpressionCost: 10},
+		}
+		unbudgeted := models.Ad{ID: uuid.New()}
+
+		charged, err := service.ChargeBudgets(ctx, []models.Ad{budgeted, unbudgeted}, now)
+		require.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]bool{budgeted.ID: true}, charged)
+
+		//half of the window has passed, so only half of the total can be spent
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{budgeted}, now)
+		require.NoError(t, err)
+		assert.False(t, charged[budgeted.ID])
+
+		later := budgeted.EndAt
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{budgeted}, later)
+		require.NoError(t, err)
+		assert.True(t, charged[budgeted.ID])
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{budgeted}, later)
+		require.NoError(t, err)
+		assert.True(t, charged[budgeted.ID])
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{budgeted}, later)
+		require.NoError(t, err)
+		assert.False(t, charged[budgeted.ID])
+
+		//the daily cap applies even when the paced limit allows more
+		daily := models.Ad{
+			ID:      uuid.New(),
+			StartAt: now.Add(-time.Hour),
+			EndAt:   now.Add(time.Hour),
+			Budget:  &models.Budget{Total: 1000, Daily: 10, ImpressionCost: 10},
+		}
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{daily}, now)
+		require.NoError(t, err)
+		assert.True(t, charged[daily.ID])
+		charged, err = service.ChargeBudgets(ctx, []models.Ad{daily}, now)
+		require.NoError(t, err)
+		assert.False(t, charged[daily.ID])
+	})
+
+	t.Run("Segments", func(t *testing.T) {
+		visitors, buyers := uuid.New(), uuid.New()
+		segments := []uuid.UUID{visitors, buyers}
+		require.NoError(t, service.ReplaceSegment(ctx, visitors, []string{"user-1", "user-2"}))
+		require.NoError(t, service.ReplaceSegment(ctx, buyers, []string{"user-2"}))
+
+		member, err := service.SegmentMemberships(ctx, "user-2", segments)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, segments, member)
+		member, err = service.SegmentMemberships(ctx, "user-3", segments)
+		require.NoError(t, err)
+		assert.Empty(t, member)
+
+		//replacing drops the previous members
+		require.NoError(t, service.ReplaceSegment(ctx, visitors, []string{"user-3"}))
+		member, err = service.SegmentMemberships(ctx, "user-1", segments)
+		require.NoError(t, err)
+		assert.Empty(t, member)
+		member, err = service.SegmentMemberships(ctx, "user-3", segments)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{visitors}, member)
+
+		require.NoError(t, service.DeleteSegment(ctx, visitors))
+		require.NoError(t, service.DeleteSegment(ctx, visitors))
+		member, err = service.SegmentMemberships(ctx, "user-3", segments)
+		require.NoError(t, err)
+		assert.Empty(t, member)
+	})
 }
@@ -0,0 +1,14 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+func claimClick(ctx context.Context, client *redis.Client, impressionID uuid.UUID, expiresAt time.Time) (bool, error) {
+	//a zero expiration would keep the key forever, so tokens expiring right now still get a short one
+	ttl := max(time.Until(expiresAt), time.Second)
+	return client.SetNX(ctx, clickKeyPrefix+impressionID.String(), 1, ttl).Result()
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"advertise_service/internal/models"
+	"cmp"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// Cursor is a position in the active ads sorted set.
+// Ads are ordered by the end time in unix seconds, which is the score, and then by id,
+// since redis orders members with the same score lexicographically and every member starts with the id.
+// The zero Cursor points before the first ad.
+type Cursor struct {
+	EndAt int64
+	ID    uuid.UUID
+}
+
+// CursorOf returns the position of the ad
+func CursorOf(ad models.Ad) Cursor {
+	return Cursor{EndAt: ad.EndAt.Unix(), ID: ad.ID}
+}
+
+// IsZero reports if the cursor points before the first ad
+func (c Cursor) IsZero() bool {
+	return c == Cursor{}
+}
+
+// Before reports if the ad comes after the cursor position
+func (c Cursor) Before(ad models.Ad) bool {
+	if c.IsZero() {
+		return true
+	}
+	return compare(c, CursorOf(ad)) < 0
+}
+
+// CompareAds orders ads the same way as they are stored in the cache
+func CompareAds(a, b models.Ad) int {
+	return compare(CursorOf(a), CursorOf(b))
+}
+
+func compare(a, b Cursor) int {
+	if c := cmp.Compare(a.EndAt, b.EndAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
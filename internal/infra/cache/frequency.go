@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+)
+
+// frequencyKey holds the amount of impressions of an ad for a viewer, it expires with the window of the frequency cap
+func frequencyKey(viewer string, adID uuid.UUID) string {
+	return frequencyKeyPrefix + viewer + ":" + adID.String()
+}
+
+func getImpressionCounts(ctx context.Context, client *redis.Client, viewer string, adIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int, len(adIDs))
+	if len(adIDs) == 0 {
+		return counts, nil
+	}
+
+	keys := make([]string, len(adIDs))
+	for i, id := range adIDs {
+		keys[i] = frequencyKey(viewer, id)
+	}
+	values, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		//missing keys are nil, meaning the viewer hasn't seen the ad within the window
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, err
+		}
+		counts[adIDs[i]] = count
+	}
+	return counts, nil
+}
+
+func incrImpressions(ctx context.Context, client *redis.Client, viewer string, ads []models.Ad) error {
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, ad := range ads {
+			if ad.FrequencyCap == nil {
+				continue
+			}
+			key := frequencyKey(viewer, ad.ID)
+			pipe.Incr(ctx, key)
+			//only the first impression starts the window
+			pipe.ExpireNX(ctx, key, ad.FrequencyCap.Window())
+		}
+		return nil
+	})
+	return err
+}
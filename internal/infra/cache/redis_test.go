@@ -85,11 +85,13 @@ func TestRedis(t *testing.T) {
 		defer rdb.Close()
 		logger, _ := zap.NewDevelopment()
 		ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
-		writeAmount, err := updateCache(ctx, rdb, slices.Clone(testData))
+		version, err := getVersion(ctx, rdb)
+		require.NoError(t, err)
+		writeAmount, err := updateCache(ctx, rdb, slices.Clone(testData), version)
 		require.NoError(t, err)
 		assert.Equal(t, 2, writeAmount)
 
-		ads, err := getAdsFromRedis(ctx, rdb, 0, 1000)
+		ads, err := getAdsAfter(ctx, rdb, Cursor{}, 1000)
 		require.NoError(t, err)
 		assert.Equal(t, 2, len(ads))
 	})
@@ -101,11 +103,15 @@ func TestRedis(t *testing.T) {
 		logger, _ := zap.NewDevelopment()
 		ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
 
-		writeAmount, err := updateCache(ctx, rdb, slices.Clone(testData))
+		version, err := getVersion(ctx, rdb)
+		require.NoError(t, err)
+		writeAmount, err := updateCache(ctx, rdb, slices.Clone(testData), version)
 		require.NoError(t, err)
 		assert.Equal(t, 2, writeAmount)
 
-		writeAmount, err = updateCache(ctx, rdb, testData)
+		version, err = getVersion(ctx, rdb)
+		require.NoError(t, err)
+		writeAmount, err = updateCache(ctx, rdb, testData, version)
 		require.NoError(t, err)
 		assert.Equal(t, 0, writeAmount)
 	})
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// segmentBatchSize is the amount of members added by a single SADD while uploading a segment
+const segmentBatchSize = 1000
+
+// segmentKey holds the members of a segment as a set
+func segmentKey(id uuid.UUID) string {
+	return segmentKeyPrefix + id.String()
+}
+
+// replaceSegment uploads the members into a staging key and renames it over the segment,
+// so requests keep seeing the previous members until the upload is complete
+func replaceSegment(ctx context.Context, client *redis.Client, id uuid.UUID, members []string) error {
+	if len(members) == 0 {
+		return client.Del(ctx, segmentKey(id)).Err()
+	}
+
+	staging := segmentKey(id) + ":upload:" + uuid.NewString()
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for start := 0; start < len(members); start += segmentBatchSize {
+			batch := members[start:min(start+segmentBatchSize, len(members))]
+			values := make([]any, len(batch))
+			for i, member := range batch {
+				values[i] = member
+			}
+			pipe.SAdd(ctx, staging, values...)
+		}
+		pipe.Rename(ctx, staging, segmentKey(id))
+		return nil
+	})
+	if err != nil {
+		//the staging key is only left behind when the upload failed halfway
+		client.Del(context.WithoutCancel(ctx), staging)
+		return err
+	}
+	return nil
+}
+
+func deleteSegment(ctx context.Context, client *redis.Client, id uuid.UUID) error {
+	return client.Del(ctx, segmentKey(id)).Err()
+}
+
+func segmentMemberships(ctx context.Context, client *redis.Client, viewer string, segments []uuid.UUID) ([]uuid.UUID, error) {
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	commands := make([]*redis.BoolCmd, len(segments))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range segments {
+			commands[i] = pipe.SIsMember(ctx, segmentKey(id), viewer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var member []uuid.UUID
+	for i, command := range commands {
+		if command.Val() {
+			member = append(member, segments[i])
+		}
+	}
+	return member, nil
+}
@@ -2,35 +2,84 @@ package infra
 
 import (
 	"github.com/joho/godotenv"
-	"log"
 	"os"
+	"strings"
 )
 
+// DefaultAddress is the address the server listens on when ADDRESS isn't set
+const DefaultAddress = ":8080"
+
 type Config struct {
-	PostgresURI   string
-	RedisURI      string
-	AutoMigration bool
+	PostgresURI string
+	RedisURI    string
+	// Address is the host:port the http server listens on
+	Address string
+	// TraceExporter is where spans are sent, one of tracing.ExporterOTLP, tracing.ExporterStdout or tracing.ExporterNone
+	TraceExporter string
+	// ClickSigningKey signs the click urls returned by get ads, it must be the same on every replica
+	ClickSigningKey string
+	// ClickBaseURL is the public url of the service prepended to click urls, they are relative when it's empty
+	ClickBaseURL string
+	// Ranking names the ranking.Strategy that orders the matched ads, defaults to ranking by end time
+	Ranking string
+	// ExtraCountries, ExtraPlatforms and ExtraGenders are targeting values accepted on top of the built-in ones
+	ExtraCountries []string
+	ExtraPlatforms []string
+	ExtraGenders   []string
+	// ExtraAttributes are the custom targeting attributes as key:type entries, e.g. app_version:semver
+	ExtraAttributes []string
 }
 
 func LoadConfig() Config {
+	config := LoadDatabaseConfig()
+	if config.RedisURI == "" {
+		panic("Missing RedisURI")
+	}
+	if config.ClickSigningKey == "" {
+		panic("Missing ClickSigningKey")
+	}
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	return config
+}
+
+// LoadDatabaseConfig only requires the postgres connection, for commands that don't serve traffic
+func LoadDatabaseConfig() Config {
 	_ = godotenv.Load()
 	config := loadFromOS()
 	if config.PostgresURI == "" {
 		panic("Missing PostgresURI")
 	}
-	if config.RedisURI == "" {
-		panic("Missing RedisURI")
-	}
-	log.Print("AUTO_MIGRATION ", config.AutoMigration)
 	return config
 }
 
 func loadFromOS() Config {
 	return Config{
-		PostgresURI:   os.Getenv("POSTGRES_URI"),
-		RedisURI:      os.Getenv("REDIS_URI"),
-		AutoMigration: os.Getenv("AUTO_MIGRATION") == "true",
+		PostgresURI:     os.Getenv("POSTGRES_URI"),
+		RedisURI:        os.Getenv("REDIS_URI"),
+		Address:         os.Getenv("ADDRESS"),
+		TraceExporter:   os.Getenv("TRACE_EXPORTER"),
+		Ranking:         os.Getenv("RANKING"),
+		ClickSigningKey: os.Getenv("CLICK_SIGNING_KEY"),
+		ClickBaseURL:    strings.TrimSuffix(os.Getenv("CLICK_BASE_URL"), "/"),
+		ExtraCountries:  splitList(os.Getenv("EXTRA_COUNTRIES")),
+		ExtraPlatforms:  splitList(os.Getenv("EXTRA_PLATFORMS")),
+		ExtraGenders:    splitList(os.Getenv("EXTRA_GENDERS")),
+		ExtraAttributes: splitList(os.Getenv("EXTRA_ATTRIBUTES")),
+	}
+}
+
+// splitList parses a comma separated env var, empty entries are dropped
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
 	}
+	return values
 }
 
 // todo: implement this
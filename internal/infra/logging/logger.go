@@ -3,6 +3,8 @@ package logging
 import (
 	"context"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net/http"
 	"time"
@@ -15,13 +17,29 @@ type LoggerMiddleware struct {
 type RequestIdContextKey struct{}
 type LoggerContextKey struct{}
 
+const (
+	// RequestIdHeader and TraceIdHeader are set on every response, so a client can report which request failed
+	RequestIdHeader = "X-Request-Id"
+	TraceIdHeader   = "X-Trace-Id"
+)
+
 func (m LoggerMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestId := uuid.New().String()
 		ctx := r.Context()
+		requestLogger := m.Logger.With(zap.String("requestId", requestId))
+		w.Header().Set(RequestIdHeader, requestId)
+
+		//the span is started by the tracing middleware, ties the logs and the response to the trace
+		span := trace.SpanFromContext(ctx)
+		if spanContext := span.SpanContext(); spanContext.HasTraceID() {
+			traceId := spanContext.TraceID().String()
+			requestLogger = requestLogger.With(zap.String("traceId", traceId))
+			w.Header().Set(TraceIdHeader, traceId)
+			span.SetAttributes(attribute.String("request.id", requestId))
+		}
 		ctx = context.WithValue(ctx, RequestIdContextKey{}, requestId)
-		ctx = context.WithValue(ctx, LoggerContextKey{}, m.Logger.With(zap.String("requestId", requestId)))
-		r.WithContext(ctx)
+		ctx = context.WithValue(ctx, LoggerContextKey{}, requestLogger)
 
 		fields := []zap.Field{
 			zap.Time("time", time.Now()),
@@ -31,6 +49,9 @@ func (m LoggerMiddleware) Middleware(next http.Handler) http.Handler {
 			zap.String("requestId", requestId),
 			zap.Int64("bytesIn", r.ContentLength),
 		}
+		if traceId := span.SpanContext().TraceID(); traceId.IsValid() {
+			fields = append(fields, zap.String("traceId", traceId.String()))
+		}
 		start := time.Now().UTC()
 		next.ServeHTTP(w, r.WithContext(ctx))
 
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "ad_service"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Handled http requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Active ad lookups served from the cache (hit) or from postgres because the cache was invalid (miss).",
+	}, []string{"result"})
+
+	cacheUpdateLock = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_update_lock_total",
+		Help:      "Attempts to take the cache update lock, contended means another replica was updating.",
+	}, []string{"result"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "postgres_query_duration_seconds",
+		Help:      "Latency of postgres queries by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	cachedActiveAds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cached_active_ads",
+		Help:      "Number of ads in the active ad cache when the targeting index was last built.",
+	})
+)
+
+// Handler serves the collected metrics in the prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware counts the requests of a route and measures their latency.
+// route should be the registered pattern instead of the path, so ids don't blow up the label cardinality.
+func Middleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		requests.WithLabelValues(route, r.Method, status).Inc()
+		requestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// CacheHit records a lookup of the active ads that was served by the cache
+func CacheHit() {
+	cacheLookups.WithLabelValues("hit").Inc()
+}
+
+// CacheMiss records a lookup of the active ads that had to fall back to postgres
+func CacheMiss() {
+	cacheLookups.WithLabelValues("miss").Inc()
+}
+
+// UpdateLockAcquired records that this replica got the cache update lock
+func UpdateLockAcquired() {
+	cacheUpdateLock.WithLabelValues("acquired").Inc()
+}
+
+// UpdateLockContended records that the cache update lock was held by someone else
+func UpdateLockContended() {
+	cacheUpdateLock.WithLabelValues("contended").Inc()
+}
+
+// ObserveQuery records the latency of a postgres query started at start, meant to be deferred
+func ObserveQuery(query string, start time.Time) {
+	queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+// SetCachedActiveAds sets the number of ads currently in the active ad cache
+func SetCachedActiveAds(count int) {
+	cachedActiveAds.Set(float64(count))
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware("/test/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/test/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/2", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/missing", nil))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues("/test/{id}", http.MethodGet, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("/test/{id}", http.MethodGet, "404")))
+}
+
+func TestHandler(t *testing.T) {
+	CacheHit()
+	SetCachedActiveAds(3)
+
+	response := httptest.NewRecorder()
+	Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, response.Code)
+	body := response.Body.String()
+	assert.True(t, strings.Contains(body, `ad_service_cache_lookups_total{result="hit"}`))
+	assert.True(t, strings.Contains(body, "ad_service_cached_active_ads 3"))
+}
@@ -0,0 +1,109 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func (db database) InsertAdvertiser(ctx context.Context, advertiser models.Advertiser) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	_, err := db.inner.ExecContext(ctx, "INSERT INTO Advertisers (id, name) VALUES ($1, $2)", advertiser.ID, advertiser.Name)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for insert advertiser", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db database) GetAdvertiser(ctx context.Context, id uuid.UUID) (models.Advertiser, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	advertiser := models.Advertiser{}
+	err := db.inner.QueryRowContext(ctx, "SELECT id, name FROM Advertisers WHERE id = $1", id).Scan(&advertiser.ID, &advertiser.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Advertiser{}, ErrAdvertiserNotFound
+	}
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query context for get advertiser", zap.Error(err))
+		return models.Advertiser{}, err
+	}
+	return advertiser, nil
+}
+
+func (db database) ListAdvertisers(ctx context.Context) ([]models.Advertiser, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	rows, err := db.inner.QueryContext(ctx, "SELECT id, name FROM Advertisers ORDER BY name, id")
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query context for list advertisers", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	advertisers := []models.Advertiser{}
+	for rows.Next() {
+		advertiser := models.Advertiser{}
+		if err := rows.Scan(&advertiser.ID, &advertiser.Name); err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return nil, err
+		}
+		advertisers = append(advertisers, advertiser)
+	}
+	return advertisers, rows.Err()
+}
+
+func (db database) UpdateAdvertiser(ctx context.Context, advertiser models.Advertiser) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	result, err := db.inner.ExecContext(ctx, "UPDATE Advertisers SET name = $1 WHERE id = $2", advertiser.Name, advertiser.ID)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for update advertiser", zap.Error(err))
+		return err
+	}
+	return expectAffected(result, ErrAdvertiserNotFound)
+}
+
+// DeleteAdvertiser removes an advertiser that has no campaigns left
+func (db database) DeleteAdvertiser(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for delete advertiser", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var campaigns int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Campaigns WHERE advertiser_id = $1", id).Scan(&campaigns)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not count campaigns of advertiser", zap.Error(err))
+		return err
+	}
+	if campaigns > 0 {
+		return ErrAdvertiserHasCampaigns
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM Advertisers WHERE id = $1", id)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for delete advertiser", zap.Error(err))
+		return err
+	}
+	if err := expectAffected(result, ErrAdvertiserNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// expectAffected returns notFound if the statement didn't change any row
+func expectAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
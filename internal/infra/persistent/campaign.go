@@ -0,0 +1,134 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const campaignColumns = "id, advertiser_id, name, start_at, end_at, status"
+
+func (db database) InsertCampaign(ctx context.Context, campaign models.Campaign) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	if campaign.Status == "" {
+		campaign.Status = models.StatusActive
+	}
+	_, err := db.inner.ExecContext(ctx, "INSERT INTO Campaigns ("+campaignColumns+") VALUES ($1, $2, $3, $4, $5, $6)",
+		campaign.ID, campaign.AdvertiserID, campaign.Name, campaign.StartAt, campaign.EndAt, campaign.Status)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for insert campaign", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db database) GetCampaign(ctx context.Context, id uuid.UUID) (models.Campaign, error) {
+	campaigns, err := queryCampaigns(ctx, db.inner, "id = $1", id)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return models.Campaign{}, ErrCampaignNotFound
+	}
+	return campaigns[0], nil
+}
+
+func (db database) ListCampaigns(ctx context.Context, advertiserID uuid.UUID) ([]models.Campaign, error) {
+	return queryCampaigns(ctx, db.inner, "advertiser_id = $1", advertiserID)
+}
+
+func (db database) UpdateCampaign(ctx context.Context, campaign models.Campaign) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	if campaign.Status == "" {
+		campaign.Status = models.StatusActive
+	}
+	result, err := db.inner.ExecContext(ctx, "UPDATE Campaigns SET name = $1, start_at = $2, end_at = $3, status = $4 WHERE id = $5",
+		campaign.Name, campaign.StartAt, campaign.EndAt, campaign.Status, campaign.ID)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for update campaign", zap.Error(err))
+		return err
+	}
+	return expectAffected(result, ErrCampaignNotFound)
+}
+
+// DeleteCampaign removes a campaign that has no ads left
+func (db database) DeleteCampaign(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for delete campaign", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	var ads int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Ads WHERE campaign_id = $1", id).Scan(&ads)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not count ads of campaign", zap.Error(err))
+		return err
+	}
+	if ads > 0 {
+		return ErrCampaignHasAds
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM Campaigns WHERE id = $1", id)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for delete campaign", zap.Error(err))
+		return err
+	}
+	if err := expectAffected(result, ErrCampaignNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db database) FindAdIDsByCampaign(ctx context.Context, campaignID uuid.UUID) ([]uuid.UUID, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	rows, err := db.inner.QueryContext(ctx, "SELECT id FROM Ads WHERE campaign_id = $1", campaignID)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query context for find ad ids by campaign", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// queryCampaigns loads the campaigns matching the where clause, sorted by start time
+func queryCampaigns(ctx context.Context, conn *sql.DB, where string, args ...any) ([]models.Campaign, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	rows, err := conn.QueryContext(ctx, "SELECT "+campaignColumns+" FROM Campaigns WHERE "+where+" ORDER BY start_at, id", args...)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query campaigns", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []models.Campaign{}
+	for rows.Next() {
+		campaign := models.Campaign{}
+		err := rows.Scan(&campaign.ID, &campaign.AdvertiserID, &campaign.Name, &campaign.StartAt, &campaign.EndAt, &campaign.Status)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+		return nil, err
+	}
+	return campaigns, nil
+}
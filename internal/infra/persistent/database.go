@@ -1,11 +1,19 @@
 package persistent
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+)
 
 type database struct {
 	inner *sql.DB
 }
 
+// execer is implemented by both *sql.DB and *sql.Tx, so statements can run inside or outside a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 func NewSQLDatabase(inner *sql.DB) Storage {
 	return database{inner: inner}
 }
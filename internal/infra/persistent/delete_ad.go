@@ -0,0 +1,37 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DeleteAd removes the ad and its conditions in a single transaction.
+func (db database) DeleteAd(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for delete ad", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteConditions(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM Ads WHERE id = $1", id)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for delete ad", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAdNotFound
+	}
+	return tx.Commit()
+}
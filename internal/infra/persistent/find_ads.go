@@ -1,66 +1,51 @@
 package persistent
 
 import (
-	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
 	"advertise_service/internal/models"
 	"context"
 	"github.com/google/uuid"
-	"go.uber.org/zap"
 	"slices"
 	"time"
 )
 
 func (db database) FindAdsWithTime(ctx context.Context, startBefore time.Time, endAfter time.Time) ([]models.Ad, error) {
-	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
-	rows, err := db.inner.QueryContext(ctx, `
-			SELECT a.id, a.title, a.start_at, a.end_at, c.min_age, c.max_age, c.male, c.female, c.ios, c.android, c.web, c.jp, c.tw
-			FROM Ads a
-			LEFT JOIN Conditions c ON a.id = c.ad_id
-			WHERE a.start_at < $1 AND a.end_at > $2
-		`, startBefore, endAfter)
-
+	defer metrics.ObserveQuery("find_ads_with_time", time.Now())
+	where := `a.start_at < $1 AND a.end_at > $2 AND a.status = $3 AND (a.campaign_id IS NULL OR a.campaign_id IN (
+				SELECT id FROM Campaigns WHERE start_at < $1 AND end_at > $2 AND status = $3))`
+	ads, err := queryAds(ctx, db.inner, where, startBefore, endAfter, models.StatusActive)
 	if err != nil {
-		logger.Log(zap.ErrorLevel, "Could not query context for find ads with time", zap.Error(err))
 		return []models.Ad{}, err
 	}
-	defer rows.Close()
-
-	ads := map[uuid.UUID]models.Ad{}
-	for rows.Next() {
-		ad := models.Ad{}
-		condition := ScannedCondition{}
-		err = rows.Scan(&ad.ID, &ad.Title, &ad.StartAt, &ad.EndAt,
-			&condition.MinAge, &condition.MaxAge, &condition.Male, &condition.Female, &condition.Ios, &condition.Android, &condition.Web, &condition.Jp, &condition.Tw)
-		if err != nil {
-			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
-			return []models.Ad{}, err
-		}
-		if _, ok := ads[ad.ID]; !ok {
-			ad.Conditions = []models.Condition{ToConditionModel(condition)}
-			ads[ad.ID] = ad
-		} else {
-			ad.Conditions = append(ads[ad.ID].Conditions, ToConditionModel(condition))
-			ads[ad.ID] = ad
-		}
-
-	}
-
-	if rows.Err() != nil {
-		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+	campaigns, err := queryCampaigns(ctx, db.inner, "id IN (SELECT a.campaign_id FROM Ads a WHERE "+where+")", startBefore, endAfter, models.StatusActive)
+	if err != nil {
 		return []models.Ad{}, err
 	}
-	values := make([]models.Ad, len(ads))
+	if len(campaigns) == 0 {
+		return ads, nil
+	}
 
-	i := 0
-	for _, v := range ads {
-		values[i] = v
-		i++
+	//ads are only served within the window of their campaign, so the returned ads are narrowed to it
+	byID := make(map[uuid.UUID]models.Campaign, len(campaigns))
+	for _, campaign := range campaigns {
+		byID[campaign.ID] = campaign
 	}
-	slices.SortFunc(values, func(i, j models.Ad) int {
-		if i.EndAt.Before(j.EndAt) {
-			return -1
+	constrained := make([]models.Ad, 0, len(ads))
+	for _, ad := range ads {
+		if ad.CampaignID != nil {
+			campaign, ok := byID[*ad.CampaignID]
+			if !ok {
+				continue
+			}
+			ad, ok = campaign.Constrain(ad)
+			if !ok || !ad.StartAt.Before(startBefore) || !ad.EndAt.After(endAfter) {
+				continue
+			}
 		}
-		return 1
+		constrained = append(constrained, ad)
+	}
+	slices.SortStableFunc(constrained, func(i, j models.Ad) int {
+		return i.EndAt.Compare(j.EndAt)
 	})
-	return values, nil
+	return constrained, nil
 }
@@ -0,0 +1,18 @@
+package persistent
+
+import (
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+)
+
+func (db database) GetAd(ctx context.Context, id uuid.UUID) (models.Ad, error) {
+	ads, err := queryAds(ctx, db.inner, "a.id = $1", id)
+	if err != nil {
+		return models.Ad{}, err
+	}
+	if len(ads) == 0 {
+		return models.Ad{}, ErrAdNotFound
+	}
+	return ads[0], nil
+}
@@ -2,44 +2,167 @@ package persistent
 
 import (
 	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
 	"advertise_service/internal/models"
 	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"strings"
+	"time"
 )
 
+// InsertAd inserts the ad and its conditions in a single transaction, so an ad is never stored with partial targeting.
 func (db database) InsertAd(ctx context.Context, ad models.Ad) error {
+	defer metrics.ObserveQuery("insert_ad", time.Now())
 	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
-	_, err := db.inner.ExecContext(ctx, "INSERT INTO Ads (id, title, start_at, end_at) VALUES ($1, $2, $3, $4)", ad.ID, ad.Title, ad.StartAt, ad.EndAt)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for insert ad", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAd(ctx, tx, ad)
 	if err != nil {
-		logger.Log(zap.ErrorLevel, "Could not execute context for insert ad", zap.Error(err))
 		return err
 	}
+	return tx.Commit()
+}
 
-	for _, condition := range ad.Conditions {
-		err = db.insertCondition(ctx, ad.ID, condition)
+// InsertAds inserts all of the ads in a single transaction, so either every ad is stored or none of them.
+func (db database) InsertAds(ctx context.Context, ads []models.Ad) error {
+	defer metrics.ObserveQuery("insert_ads", time.Now())
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for insert ads", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, ad := range ads {
+		err = insertAd(ctx, tx, ad)
 		if err != nil {
-			return err
+			return InsertAdsError{Index: i, Err: err}
 		}
 	}
-	return nil
+	return tx.Commit()
+}
+
+// InsertAdsError reports which ad of InsertAds failed, none of the ads are stored
+type InsertAdsError struct {
+	Index int
+	Err   error
 }
 
-func (db database) insertCondition(ctx context.Context, parentAdID uuid.UUID, condition models.Condition) error {
+func (e InsertAdsError) Error() string {
+	return fmt.Sprintf("ad %d: %v", e.Index, e.Err)
+}
+
+func (e InsertAdsError) Unwrap() error {
+	return e.Err
+}
+
+// insertAd writes the ad row and its conditions within the transaction of the caller
+func insertAd(ctx context.Context, conn execer, ad models.Ad) error {
 	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
-	stmt, err := db.inner.PrepareContext(ctx, "INSERT INTO Conditions (id, ad_id, ios, android, web, jp, tw, male, female, min_age, max_age) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)")
+	if ad.Status == "" {
+		ad.Status = models.StatusActive
+	}
+	capMax, capWindow := frequencyCapColumns(ad.FrequencyCap)
+	budgetTotal, budgetDaily, impressionCost := budgetColumns(ad.Budget)
+	schedule, err := jsonColumn(ad.Schedule)
+	if err != nil {
+		return err
+	}
+	targeting, err := jsonColumn(ad.Targeting)
 	if err != nil {
-		logger.Log(zap.ErrorLevel, "Could not prepare context for insert condition", zap.Error(err))
 		return err
 	}
-	defer stmt.Close()
-	schema := FromConditionModel(condition)
+	_, err = conn.ExecContext(ctx, `INSERT INTO Ads (id, title, start_at, end_at, status, campaign_id, frequency_cap_max, frequency_cap_window,
+		budget_total, budget_daily, budget_impression_cost, priority, weight, description, image_url, click_url, call_to_action, schedule, targeting)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)`,
+		ad.ID, ad.Title, ad.StartAt, ad.EndAt, ad.Status, campaignColumn(ad.CampaignID), capMax, capWindow,
+		budgetTotal, budgetDaily, impressionCost, ad.Priority, ad.Weight, ad.Description, ad.ImageURL, ad.ClickURL, ad.CallToAction, schedule, targeting)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for insert ad", zap.Error(err))
+		return err
+	}
+
+	return insertConditions(ctx, conn, ad.ID, ad.Conditions)
+}
 
-	_, err = stmt.ExecContext(ctx, uuid.New(), parentAdID,
-		schema.Ios, schema.Android, schema.Web, schema.Jp, schema.Tw, schema.Male, schema.Female, schema.MinAge, schema.MaxAge)
+// rowsPerStatement keeps the amount of placeholders of a single multi row insert below the limits of the drivers
+const rowsPerStatement = 500
 
+// insertConditions writes all conditions of the ad with one multi row insert per table,
+// instead of a statement per condition and targeting value.
+func insertConditions(ctx context.Context, conn execer, parentAdID uuid.UUID, conditions []models.Condition) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	conditionRows := make([][]any, len(conditions))
+	dimensionRows := make([][][]any, len(conditionDimensions))
+	for position, condition := range conditions {
+		conditionID := uuid.New()
+		conditionRows[position] = []any{conditionID, parentAdID, position, condition.AgeStart, condition.AgeEnd}
+		for d, dimension := range conditionDimensions {
+			for i, value := range dimension.values(condition) {
+				dimensionRows[d] = append(dimensionRows[d], []any{conditionID, i, value})
+			}
+		}
+	}
+
+	err := insertRows(ctx, conn, "Conditions", []string{"id", "ad_id", "position", "min_age", "max_age"}, conditionRows)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not insert conditions", zap.Error(err))
+		return err
+	}
+	for d, dimension := range conditionDimensions {
+		err = insertRows(ctx, conn, dimension.table, []string{"condition_id", "position", dimension.column}, dimensionRows[d])
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "Could not insert condition "+dimension.column, zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRows inserts the rows into the table, rowsPerStatement rows at a time
+func insertRows(ctx context.Context, conn execer, table string, columns []string, rows [][]any) error {
+	for start := 0; start < len(rows); start += rowsPerStatement {
+		batch := rows[start:min(start+rowsPerStatement, len(rows))]
+		values := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*len(columns))
+		for i, row := range batch {
+			placeholders := make([]string, len(row))
+			for j := range row {
+				placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+			}
+			values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+			args = append(args, row...)
+		}
+
+		_, err := conn.ExecContext(ctx, "INSERT INTO "+table+" ("+strings.Join(columns, ", ")+") VALUES "+strings.Join(values, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteConditions removes every condition of the ad, dimension rows first since they reference the conditions
+func deleteConditions(ctx context.Context, conn execer, adID uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	for _, dimension := range conditionDimensions {
+		_, err := conn.ExecContext(ctx, "DELETE FROM "+dimension.table+" WHERE condition_id IN (SELECT id FROM Conditions WHERE ad_id = $1)", adID)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "Could not delete condition "+dimension.column, zap.Error(err))
+			return err
+		}
+	}
+	_, err := conn.ExecContext(ctx, "DELETE FROM Conditions WHERE ad_id = $1", adID)
 	if err != nil {
-		logger.Log(zap.ErrorLevel, "Could not insert condition", zap.Error(err))
+		logger.Log(zap.ErrorLevel, "Could not delete conditions", zap.Error(err))
 		return err
 	}
 	return nil
@@ -0,0 +1,45 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// eventsPerStatement keeps the amount of placeholders of a single insert below the limits of the drivers
+const eventsPerStatement = 500
+
+// InsertEvents inserts the events in batches within a single transaction
+func (db database) InsertEvents(ctx context.Context, events []models.Event) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for insert events", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(events); start += eventsPerStatement {
+		batch := events[start:min(start+eventsPerStatement, len(events))]
+		values := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*9)
+		for i, event := range batch {
+			n := i * 9
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+			occurredAt := event.OccurredAt.UTC()
+			args = append(args, event.ID, event.AdID, event.Kind, occurredAt, occurredAt.Truncate(24*time.Hour),
+				event.Params.Age, event.Params.Gender, event.Params.Country, event.Params.Platform)
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO AdEvents (id, ad_id, kind, occurred_at, day, age, gender, country, platform) VALUES "+strings.Join(values, ", "), args...)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "Could not insert events", zap.Error(err), zap.Int("amount", len(batch)))
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,305 @@
+package persistent
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change, loaded from migrations/<version>_<name>.up.sql and the matching .down.sql
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// LoadMigrations returns the embedded migrations sorted by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s should end with .up.sql or .down.sql", fileName)
+		}
+		versionText, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s should start with a positive version", fileName)
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := migrations[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			migrations[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	slices.SortFunc(result, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	return result, nil
+}
+
+// migrationLockKey identifies the postgres advisory lock held while migrating
+const migrationLockKey = 7_412_309_845
+
+// migrationConn is the connection migrations run on, the advisory lock belongs to the session so every statement has to use it
+type migrationConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// withMigrationLock runs migrate on a single connection holding the advisory lock,
+// so replicas starting at the same time apply pending migrations one after another instead of racing for them.
+func withMigrationLock(ctx context.Context, db *sql.DB, migrate func(conn migrationConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return err
+	}
+	err = migrate(conn)
+	_, unlockErr := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	if unlockErr != nil {
+		//closing the session releases the lock, instead of returning a connection holding it to the pool
+		conn.Raw(func(any) error {
+			return driver.ErrBadConn
+		})
+	}
+	return errors.Join(err, unlockErr)
+}
+
+func createMigrationTable(ctx context.Context, db migrationConn) error {
+	_, err := db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`)
+	return err
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations with the time they were applied
+func appliedMigrations(ctx context.Context, db migrationConn) (map[int]time.Time, error) {
+	if err := createMigrationTable(ctx, db); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies every pending migration in order and returns the versions that were applied.
+// Each migration runs in its own transaction together with its schema_migrations row,
+// so a failed migration leaves the database at the previous version.
+// The applied migrations are read after taking the migration lock, so migrations applied by another replica meanwhile are skipped.
+func MigrateUp(ctx context.Context, db *sql.DB) ([]int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	err = withMigrationLock(ctx, db, func(conn migrationConn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err = runMigration(ctx, conn, migration.Up,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// MigrateDown reverts the latest steps applied migrations and returns the versions that were reverted
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) ([]int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	err = withMigrationLock(ctx, db, func(conn migrationConn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			err = runMigration(ctx, conn, migration.Down, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// GetMigrationStatus lists every known migration and when it was applied, nil if it is pending
+func GetMigrationStatus(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// runMigration records the migration first, so a runner that doesn't take the migration lock blocks on the schema_migrations row
+// and then fails instead of applying the same statements twice.
+func runMigration(ctx context.Context, db migrationConn, statements string, record string, args ...any) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != 1 {
+		return errors.New("migration was changed by another runner")
+	}
+	if _, err = tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestMigrations(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	t.Run("Up", func(t *testing.T) {
+		done, err := MigrateUp(ctx, db)
+		require.NoError(t, err)
+		require.Len(t, done, len(migrations))
+
+		done, err = MigrateUp(ctx, db)
+		require.NoError(t, err)
+		require.Empty(t, done)
+
+		statuses, err := GetMigrationStatus(ctx, db)
+		require.NoError(t, err)
+		for _, status := range statuses {
+			require.NotNil(t, status.AppliedAt)
+		}
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		done, err := MigrateDown(ctx, db, 1)
+		require.NoError(t, err)
+		require.Equal(t, []int{migrations[len(migrations)-1].Version}, done)
+
+		statuses, err := GetMigrationStatus(ctx, db)
+		require.NoError(t, err)
+		require.Nil(t, statuses[len(statuses)-1].AppliedAt)
+		require.NotNil(t, statuses[0].AppliedAt)
+
+		done, err = MigrateDown(ctx, db, len(migrations))
+		require.NoError(t, err)
+		require.Len(t, done, len(migrations)-1)
+	})
+
+	t.Run("UpAgain", func(t *testing.T) {
+		done, err := MigrateUp(ctx, db)
+		require.NoError(t, err)
+		require.Len(t, done, len(migrations))
+	})
+}
@@ -1,2 +1,2 @@
 DROP TABLE IF EXISTS Conditions;
-DROP TABLE IF EXISTS Ads;
\ No newline at end of file
+DROP TABLE IF EXISTS Ads;
@@ -20,4 +20,4 @@ CREATE TABLE IF NOT EXISTS Conditions (
     CONSTRAINT fk_ad
         FOREIGN KEY(ad_id)
         REFERENCES Ads(id)
-);
\ No newline at end of file
+);
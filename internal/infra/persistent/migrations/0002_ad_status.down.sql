@@ -0,0 +1 @@
+ALTER TABLE Ads DROP COLUMN status;
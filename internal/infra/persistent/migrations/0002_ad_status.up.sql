@@ -0,0 +1 @@
+ALTER TABLE Ads ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
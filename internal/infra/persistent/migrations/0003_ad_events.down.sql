@@ -0,0 +1,2 @@
+DROP INDEX ad_events_ad_day;
+DROP TABLE AdEvents;
@@ -0,0 +1,13 @@
+CREATE TABLE AdEvents (
+    id uuid PRIMARY KEY,
+    ad_id uuid NOT NULL,
+    kind TEXT NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    day DATE NOT NULL,
+    age INT NOT NULL,
+    gender TEXT NOT NULL,
+    country TEXT NOT NULL,
+    platform TEXT NOT NULL
+);
+
+CREATE INDEX ad_events_ad_day ON AdEvents (ad_id, day);
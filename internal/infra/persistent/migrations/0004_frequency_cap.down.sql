@@ -0,0 +1,2 @@
+ALTER TABLE Ads DROP COLUMN frequency_cap_window;
+ALTER TABLE Ads DROP COLUMN frequency_cap_max;
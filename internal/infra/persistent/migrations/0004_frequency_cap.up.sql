@@ -0,0 +1,2 @@
+ALTER TABLE Ads ADD COLUMN frequency_cap_max INT;
+ALTER TABLE Ads ADD COLUMN frequency_cap_window INT;
@@ -0,0 +1,20 @@
+ALTER TABLE Conditions ADD COLUMN jp BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN tw BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN ios BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN android BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN web BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN male BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Conditions ADD COLUMN female BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE Conditions SET jp = EXISTS (SELECT 1 FROM ConditionCountries d WHERE d.condition_id = Conditions.id AND d.country = 'JP');
+UPDATE Conditions SET tw = EXISTS (SELECT 1 FROM ConditionCountries d WHERE d.condition_id = Conditions.id AND d.country = 'TW');
+UPDATE Conditions SET ios = EXISTS (SELECT 1 FROM ConditionPlatforms d WHERE d.condition_id = Conditions.id AND d.platform = 'ios');
+UPDATE Conditions SET android = EXISTS (SELECT 1 FROM ConditionPlatforms d WHERE d.condition_id = Conditions.id AND d.platform = 'android');
+UPDATE Conditions SET web = EXISTS (SELECT 1 FROM ConditionPlatforms d WHERE d.condition_id = Conditions.id AND d.platform = 'web');
+UPDATE Conditions SET male = EXISTS (SELECT 1 FROM ConditionGenders d WHERE d.condition_id = Conditions.id AND d.gender = 'M');
+UPDATE Conditions SET female = EXISTS (SELECT 1 FROM ConditionGenders d WHERE d.condition_id = Conditions.id AND d.gender = 'F');
+
+DROP TABLE ConditionGenders;
+DROP TABLE ConditionPlatforms;
+DROP TABLE ConditionCountries;
+ALTER TABLE Conditions DROP COLUMN position;
@@ -0,0 +1,49 @@
+ALTER TABLE Conditions ADD COLUMN position INT NOT NULL DEFAULT 0;
+
+CREATE TABLE ConditionCountries (
+    condition_id uuid NOT NULL,
+    position INT NOT NULL,
+    country TEXT NOT NULL,
+    PRIMARY KEY (condition_id, country),
+    CONSTRAINT fk_condition
+        FOREIGN KEY(condition_id)
+        REFERENCES Conditions(id)
+);
+
+INSERT INTO ConditionCountries (condition_id, position, country) SELECT id, 0, 'JP' FROM Conditions WHERE jp;
+INSERT INTO ConditionCountries (condition_id, position, country) SELECT id, 1, 'TW' FROM Conditions WHERE tw;
+
+CREATE TABLE ConditionPlatforms (
+    condition_id uuid NOT NULL,
+    position INT NOT NULL,
+    platform TEXT NOT NULL,
+    PRIMARY KEY (condition_id, platform),
+    CONSTRAINT fk_condition
+        FOREIGN KEY(condition_id)
+        REFERENCES Conditions(id)
+);
+
+INSERT INTO ConditionPlatforms (condition_id, position, platform) SELECT id, 0, 'ios' FROM Conditions WHERE ios;
+INSERT INTO ConditionPlatforms (condition_id, position, platform) SELECT id, 1, 'android' FROM Conditions WHERE android;
+INSERT INTO ConditionPlatforms (condition_id, position, platform) SELECT id, 2, 'web' FROM Conditions WHERE web;
+
+CREATE TABLE ConditionGenders (
+    condition_id uuid NOT NULL,
+    position INT NOT NULL,
+    gender TEXT NOT NULL,
+    PRIMARY KEY (condition_id, gender),
+    CONSTRAINT fk_condition
+        FOREIGN KEY(condition_id)
+        REFERENCES Conditions(id)
+);
+
+INSERT INTO ConditionGenders (condition_id, position, gender) SELECT id, 0, 'M' FROM Conditions WHERE male;
+INSERT INTO ConditionGenders (condition_id, position, gender) SELECT id, 1, 'F' FROM Conditions WHERE female;
+
+ALTER TABLE Conditions DROP COLUMN jp;
+ALTER TABLE Conditions DROP COLUMN tw;
+ALTER TABLE Conditions DROP COLUMN ios;
+ALTER TABLE Conditions DROP COLUMN android;
+ALTER TABLE Conditions DROP COLUMN web;
+ALTER TABLE Conditions DROP COLUMN male;
+ALTER TABLE Conditions DROP COLUMN female;
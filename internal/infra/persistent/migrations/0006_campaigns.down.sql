@@ -0,0 +1,5 @@
+DROP INDEX ads_campaign;
+ALTER TABLE Ads DROP COLUMN campaign_id;
+DROP INDEX campaigns_advertiser;
+DROP TABLE Campaigns;
+DROP TABLE Advertisers;
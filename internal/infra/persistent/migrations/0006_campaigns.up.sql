@@ -0,0 +1,22 @@
+CREATE TABLE Advertisers (
+    id uuid PRIMARY KEY,
+    name TEXT NOT NULL
+);
+
+CREATE TABLE Campaigns (
+    id uuid PRIMARY KEY,
+    advertiser_id uuid NOT NULL,
+    name TEXT NOT NULL,
+    start_at TIMESTAMP NOT NULL,
+    end_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    CONSTRAINT fk_advertiser
+        FOREIGN KEY(advertiser_id)
+        REFERENCES Advertisers(id)
+);
+
+CREATE INDEX campaigns_advertiser ON Campaigns (advertiser_id);
+
+ALTER TABLE Ads ADD COLUMN campaign_id uuid REFERENCES Campaigns(id);
+
+CREATE INDEX ads_campaign ON Ads (campaign_id);
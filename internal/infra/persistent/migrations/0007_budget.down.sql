@@ -0,0 +1,3 @@
+ALTER TABLE Ads DROP COLUMN budget_impression_cost;
+ALTER TABLE Ads DROP COLUMN budget_daily;
+ALTER TABLE Ads DROP COLUMN budget_total;
@@ -0,0 +1,3 @@
+ALTER TABLE Ads ADD COLUMN budget_total BIGINT;
+ALTER TABLE Ads ADD COLUMN budget_daily BIGINT;
+ALTER TABLE Ads ADD COLUMN budget_impression_cost BIGINT;
@@ -0,0 +1,2 @@
+ALTER TABLE Ads DROP COLUMN weight;
+ALTER TABLE Ads DROP COLUMN priority;
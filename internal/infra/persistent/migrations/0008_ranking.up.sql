@@ -0,0 +1,2 @@
+ALTER TABLE Ads ADD COLUMN priority INT NOT NULL DEFAULT 0;
+ALTER TABLE Ads ADD COLUMN weight INT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE Ads DROP COLUMN call_to_action;
+ALTER TABLE Ads DROP COLUMN click_url;
+ALTER TABLE Ads DROP COLUMN image_url;
+ALTER TABLE Ads DROP COLUMN description;
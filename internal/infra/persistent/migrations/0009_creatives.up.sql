@@ -0,0 +1,4 @@
+ALTER TABLE Ads ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE Ads ADD COLUMN image_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE Ads ADD COLUMN click_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE Ads ADD COLUMN call_to_action TEXT NOT NULL DEFAULT '';
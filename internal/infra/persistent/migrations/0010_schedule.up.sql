@@ -0,0 +1 @@
+ALTER TABLE Ads ADD COLUMN schedule TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE ConditionSegments;
+DROP TABLE Segments;
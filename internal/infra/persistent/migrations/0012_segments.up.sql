@@ -0,0 +1,16 @@
+CREATE TABLE Segments (
+    id uuid PRIMARY KEY,
+    name TEXT NOT NULL,
+    size INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE ConditionSegments (
+    condition_id uuid NOT NULL,
+    position INT NOT NULL,
+    segment uuid NOT NULL,
+    PRIMARY KEY (condition_id, segment),
+    CONSTRAINT fk_condition
+        FOREIGN KEY(condition_id)
+        REFERENCES Conditions(id)
+);
@@ -0,0 +1,7 @@
+package persistent
+
+import "context"
+
+func (db database) Ping(ctx context.Context) error {
+	return db.inner.PingContext(ctx)
+}
@@ -0,0 +1,47 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"time"
+)
+
+// ReportEvents counts the impressions and clicks of an ad per day, gender, country and platform.
+// from and to are days in UTC, both inclusive.
+func (db database) ReportEvents(ctx context.Context, adID uuid.UUID, from time.Time, to time.Time) ([]models.EventReportRow, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	rows, err := db.inner.QueryContext(ctx, `
+			SELECT day, gender, country, platform,
+				SUM(CASE WHEN kind = $1 THEN 1 ELSE 0 END),
+				SUM(CASE WHEN kind = $2 THEN 1 ELSE 0 END)
+			FROM AdEvents
+			WHERE ad_id = $3 AND day >= $4 AND day <= $5
+			GROUP BY day, gender, country, platform
+			ORDER BY day, gender, country, platform
+		`, models.Impression, models.Click, adID, from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour))
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query context for report events", zap.Error(err))
+		return []models.EventReportRow{}, err
+	}
+	defer rows.Close()
+
+	report := make([]models.EventReportRow, 0)
+	for rows.Next() {
+		row := models.EventReportRow{}
+		err = rows.Scan(&row.Day, &row.Gender, &row.Country, &row.Platform, &row.Impressions, &row.Clicks)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return []models.EventReportRow{}, err
+		}
+		row.Day = row.Day.UTC()
+		report = append(report, row)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+		return []models.EventReportRow{}, err
+	}
+	return report, nil
+}
@@ -0,0 +1,177 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+)
+
+// adColumns are the columns scanned by scanAds, Ads is aliased as a and Conditions as c
+const adColumns = `a.id, a.title, a.start_at, a.end_at, a.status, a.campaign_id, a.frequency_cap_max, a.frequency_cap_window,
+			a.budget_total, a.budget_daily, a.budget_impression_cost, a.priority, a.weight,
+			a.description, a.image_url, a.click_url, a.call_to_action, a.schedule, a.targeting,
+			c.id, c.min_age, c.max_age`
+
+// scannedAd is an ad whose conditions are still being loaded from the dimension tables
+type scannedAd struct {
+	ad           models.Ad
+	conditionIDs []uuid.UUID
+}
+
+// queryAds loads the ads matching the where clause together with their conditions.
+// The where clause may only refer to Ads as a, since it is reused to load every condition dimension.
+// Every read runs in the same snapshot, otherwise conditions replaced by UpdateAd in between would be loaded
+// without their dimensions and match every viewer.
+// The result is sorted by end time ascending.
+func queryAds(ctx context.Context, db *sql.DB, where string, args ...any) ([]models.Ad, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	conn, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for query ads", zap.Error(err))
+		return []models.Ad{}, err
+	}
+	defer conn.Rollback()
+
+	rows, err := conn.QueryContext(ctx, `
+			SELECT `+adColumns+`
+			FROM Ads a
+			LEFT JOIN Conditions c ON a.id = c.ad_id
+			WHERE `+where+`
+			ORDER BY a.id, c.position
+		`, args...)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query ads", zap.Error(err))
+		return []models.Ad{}, err
+	}
+	ads, conditions, err := scanAds(ctx, rows)
+	rows.Close()
+	if err != nil {
+		return []models.Ad{}, err
+	}
+
+	if len(conditions) > 0 {
+		for _, dimension := range conditionDimensions {
+			err = queryDimension(ctx, conn, dimension, conditions, where, args...)
+			if err != nil {
+				return []models.Ad{}, err
+			}
+		}
+	}
+	if err = conn.Commit(); err != nil {
+		logger.Log(zap.ErrorLevel, "Could not commit query ads", zap.Error(err))
+		return []models.Ad{}, err
+	}
+
+	values := make([]models.Ad, 0, len(ads))
+	for _, scanned := range ads {
+		ad := scanned.ad
+		for _, id := range scanned.conditionIDs {
+			ad.Conditions = append(ad.Conditions, *conditions[id])
+		}
+		values = append(values, ad)
+	}
+	slices.SortFunc(values, func(i, j models.Ad) int {
+		if i.EndAt.Before(j.EndAt) {
+			return -1
+		}
+		return 1
+	})
+	return values, nil
+}
+
+// scanAds reads rows of ads left joined with their conditions, and groups the condition ids by ad.
+func scanAds(ctx context.Context, rows *sql.Rows) (map[uuid.UUID]*scannedAd, map[uuid.UUID]*models.Condition, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	ads := map[uuid.UUID]*scannedAd{}
+	conditions := map[uuid.UUID]*models.Condition{}
+	for rows.Next() {
+		ad := models.Ad{}
+		var conditionID, campaignID uuid.NullUUID
+		var minAge, maxAge sql.NullInt64
+		var capMax, capWindow sql.NullInt64
+		var budgetTotal, budgetDaily, impressionCost sql.NullInt64
+		var schedule, targeting sql.NullString
+		err := rows.Scan(&ad.ID, &ad.Title, &ad.StartAt, &ad.EndAt, &ad.Status, &campaignID, &capMax, &capWindow,
+			&budgetTotal, &budgetDaily, &impressionCost, &ad.Priority, &ad.Weight,
+			&ad.Description, &ad.ImageURL, &ad.ClickURL, &ad.CallToAction, &schedule, &targeting,
+			&conditionID, &minAge, &maxAge)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return nil, nil, err
+		}
+		if campaignID.Valid {
+			ad.CampaignID = &campaignID.UUID
+		}
+		if capMax.Valid && capWindow.Valid {
+			ad.FrequencyCap = &models.FrequencyCap{Max: int(capMax.Int64), WindowSeconds: capWindow.Int64}
+		}
+		if budgetTotal.Valid && impressionCost.Valid {
+			ad.Budget = &models.Budget{Total: budgetTotal.Int64, Daily: budgetDaily.Int64, ImpressionCost: impressionCost.Int64}
+		}
+		ad.Schedule, err = scanJSONColumn[models.Schedule](schedule)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error decoding schedule", zap.Error(err))
+			return nil, nil, err
+		}
+		ad.Targeting, err = scanJSONColumn[models.Expression](targeting)
+		if err != nil {
+			logger.Log(zap.ErrorLevel, "error decoding targeting", zap.Error(err))
+			return nil, nil, err
+		}
+		scanned, ok := ads[ad.ID]
+		if !ok {
+			scanned = &scannedAd{ad: ad}
+			ads[ad.ID] = scanned
+		}
+		if conditionID.Valid {
+			scanned.conditionIDs = append(scanned.conditionIDs, conditionID.UUID)
+			conditions[conditionID.UUID] = &models.Condition{AgeStart: int(minAge.Int64), AgeEnd: int(maxAge.Int64)}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+		return nil, nil, err
+	}
+	return ads, conditions, nil
+}
+
+// queryDimension adds the values of one dimension to the conditions of the ads matching the where clause,
+// it must run in the snapshot of the query that scanned the conditions
+func queryDimension(ctx context.Context, conn *sql.Tx, dimension conditionDimension, conditions map[uuid.UUID]*models.Condition, where string, args ...any) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	rows, err := conn.QueryContext(ctx, `
+			SELECT d.condition_id, d.`+dimension.column+`
+			FROM `+dimension.table+` d
+			JOIN Conditions c ON c.id = d.condition_id
+			JOIN Ads a ON a.id = c.ad_id
+			WHERE `+where+`
+			ORDER BY d.condition_id, d.position
+		`, args...)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query condition "+dimension.column, zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conditionID uuid.UUID
+		var value string
+		if err := rows.Scan(&conditionID, &value); err != nil {
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return err
+		}
+		if condition, ok := conditions[conditionID]; ok {
+			dimension.add(condition, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -2,77 +2,116 @@ package persistent
 
 import (
 	"advertise_service/internal/models"
-	"slices"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
 )
 
-type ScannedCondition struct {
-	Ios     *bool
-	Android *bool
-	Web     *bool
-	Jp      *bool
-	Tw      *bool
-	Male    *bool
-	Female  *bool
-	MinAge  *int
-	MaxAge  *int
+// conditionDimension describes a join table holding one targeting value per row for a condition
+type conditionDimension struct {
+	table  string
+	column string
+	values func(condition models.Condition) []string
+	add    func(condition *models.Condition, value string)
 }
 
-func FromConditionModel(m models.Condition) ScannedCondition {
-	ios := slices.Contains(m.Platform, models.Ios)
-	android := slices.Contains(m.Platform, models.Android)
-	web := slices.Contains(m.Platform, models.Web)
-	jp := slices.Contains(m.Country, models.Japan)
-	tw := slices.Contains(m.Country, models.Taiwan)
-	male := slices.Contains(m.Gender, models.Male)
-	female := slices.Contains(m.Gender, models.Female)
-	return ScannedCondition{
-		Ios:     &ios,
-		Android: &android,
-		Web:     &web,
-		Jp:      &jp,
-		Tw:      &tw,
-		MaxAge:  &m.AgeEnd,
-		MinAge:  &m.AgeStart,
-		Male:    &male,
-		Female:  &female,
-	}
+// conditionDimensions are the targeting dimensions stored outside the Conditions table,
+// new enum values need no schema change since they are only rows in these tables.
+var conditionDimensions = []conditionDimension{
+	{
+		table:  "ConditionCountries",
+		column: "country",
+		values: func(condition models.Condition) []string { return toStrings(condition.Country) },
+		add: func(condition *models.Condition, value string) {
+			condition.Country = append(condition.Country, models.Country(value))
+		},
+	},
+	{
+		table:  "ConditionPlatforms",
+		column: "platform",
+		values: func(condition models.Condition) []string { return toStrings(condition.Platform) },
+		add: func(condition *models.Condition, value string) {
+			condition.Platform = append(condition.Platform, models.Platform(value))
+		},
+	},
+	{
+		table:  "ConditionGenders",
+		column: "gender",
+		values: func(condition models.Condition) []string { return toStrings(condition.Gender) },
+		add: func(condition *models.Condition, value string) {
+			condition.Gender = append(condition.Gender, value)
+		},
+	},
+	{
+		table:  "ConditionSegments",
+		column: "segment",
+		values: func(condition models.Condition) []string {
+			segments := make([]string, len(condition.Segments))
+			for i, id := range condition.Segments {
+				segments[i] = id.String()
+			}
+			return segments
+		},
+		add: func(condition *models.Condition, value string) {
+			if id, err := uuid.Parse(value); err == nil {
+				condition.Segments = append(condition.Segments, id)
+			}
+		},
+	},
 }
 
-func ToConditionModel(schema ScannedCondition) models.Condition {
-	if schema.Ios == nil {
-		return models.Condition{}
+func toStrings[T ~string](values []T) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = string(value)
 	}
+	return result
+}
 
-	var platform []models.Platform
-	var country []models.Country
-	var genders []models.Gender
-	if *schema.Ios {
-		platform = append(platform, models.Ios)
-	}
-	if *schema.Android {
-		platform = append(platform, models.Android)
+// frequencyCapColumns maps an optional frequency cap to nullable columns
+func frequencyCapColumns(frequencyCap *models.FrequencyCap) (sql.NullInt64, sql.NullInt64) {
+	if frequencyCap == nil {
+		return sql.NullInt64{}, sql.NullInt64{}
 	}
-	if *schema.Web {
-		platform = append(platform, models.Web)
+	return sql.NullInt64{Int64: int64(frequencyCap.Max), Valid: true}, sql.NullInt64{Int64: frequencyCap.WindowSeconds, Valid: true}
+}
+
+// budgetColumns maps an optional budget to nullable columns
+func budgetColumns(budget *models.Budget) (sql.NullInt64, sql.NullInt64, sql.NullInt64) {
+	if budget == nil {
+		return sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}
 	}
-	if *schema.Jp {
-		country = append(country, models.Japan)
+	return sql.NullInt64{Int64: budget.Total, Valid: true}, sql.NullInt64{Int64: budget.Daily, Valid: true}, sql.NullInt64{Int64: budget.ImpressionCost, Valid: true}
+}
+
+// jsonColumn stores an optional value that is only read together with its ad as json, such as the schedule
+func jsonColumn[T any](value *T) (sql.NullString, error) {
+	if value == nil {
+		return sql.NullString{}, nil
 	}
-	if *schema.Tw {
-		country = append(country, models.Taiwan)
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
 	}
-	if *schema.Male {
-		genders = append(genders, models.Male)
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+// scanJSONColumn decodes a column written by jsonColumn, NULL is a nil value
+func scanJSONColumn[T any](column sql.NullString) (*T, error) {
+	if !column.Valid {
+		return nil, nil
 	}
-	if *schema.Female {
-		genders = append(genders, models.Female)
+	value := new(T)
+	if err := json.Unmarshal([]byte(column.String), value); err != nil {
+		return nil, err
 	}
+	return value, nil
+}
 
-	return models.Condition{
-		Platform: platform,
-		Country:  country,
-		Gender:   genders,
-		AgeEnd:   *schema.MaxAge,
-		AgeStart: *schema.MinAge,
+// campaignColumn maps the optional campaign of an ad to a nullable column
+func campaignColumn(campaignID *uuid.UUID) uuid.NullUUID {
+	if campaignID == nil {
+		return uuid.NullUUID{}
 	}
+	return uuid.NullUUID{UUID: *campaignID, Valid: true}
 }
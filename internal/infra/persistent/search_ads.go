@@ -0,0 +1,175 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/metrics"
+	"advertise_service/internal/models"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdSort is a column SearchAds can sort by
+type AdSort string
+
+const (
+	SortByStartAt AdSort = "start_at"
+	SortByEndAt   AdSort = "end_at"
+	SortByTitle   AdSort = "title"
+)
+
+// ValidAdSort reports if the ads can be sorted by the column
+func ValidAdSort(sort AdSort) bool {
+	return sort == SortByStartAt || sort == SortByEndAt || sort == SortByTitle
+}
+
+// AdSearch filters, sorts and paginates every stored ad regardless of whether it's active, a zero filter doesn't restrict the ads
+type AdSearch struct {
+	Status models.Status
+	// Title matches the ads whose title contains it, ignoring case
+	Title string
+	// StartFrom and StartTo bound the start time, EndFrom and EndTo the end time, all of them inclusive
+	StartFrom time.Time
+	StartTo   time.Time
+	EndFrom   time.Time
+	EndTo     time.Time
+	// Country and Platform match the ads with a condition listing the value,
+	// ads targeted by an expression or without conditions aren't matched
+	Country  models.Country
+	Platform models.Platform
+
+	// Sort defaults to the start time, ties are broken by id so pages are stable
+	Sort       AdSort
+	Descending bool
+	// Limit must be positive
+	Limit  int
+	Offset int
+}
+
+// whereBuilder joins the clauses of a dynamic where clause with AND, numbering the placeholders of their arguments
+type whereBuilder struct {
+	clauses []string
+	args    []any
+}
+
+// add appends a clause, every %s in it is replaced by the placeholder of the next argument
+func (w *whereBuilder) add(clause string, args ...any) {
+	placeholders := make([]any, len(args))
+	for i, arg := range args {
+		w.args = append(w.args, arg)
+		placeholders[i] = fmt.Sprintf("$%d", len(w.args))
+	}
+	w.clauses = append(w.clauses, fmt.Sprintf(clause, placeholders...))
+}
+
+func (w *whereBuilder) String() string {
+	if len(w.clauses) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(w.clauses, " AND ")
+}
+
+func (search AdSearch) where() *whereBuilder {
+	where := &whereBuilder{}
+	if search.Status != "" {
+		where.add("a.status = %s", search.Status)
+	}
+	if search.Title != "" {
+		where.add(`LOWER(a.title) LIKE %s ESCAPE '\'`, "%"+escapeLike(strings.ToLower(search.Title))+"%")
+	}
+	if !search.StartFrom.IsZero() {
+		where.add("a.start_at >= %s", search.StartFrom)
+	}
+	if !search.StartTo.IsZero() {
+		where.add("a.start_at <= %s", search.StartTo)
+	}
+	if !search.EndFrom.IsZero() {
+		where.add("a.end_at >= %s", search.EndFrom)
+	}
+	if !search.EndTo.IsZero() {
+		where.add("a.end_at <= %s", search.EndTo)
+	}
+	if search.Country != "" {
+		where.add(`EXISTS (SELECT 1 FROM Conditions c JOIN ConditionCountries d ON d.condition_id = c.id
+				WHERE c.ad_id = a.id AND d.country = %s)`, search.Country)
+	}
+	if search.Platform != "" {
+		where.add(`EXISTS (SELECT 1 FROM Conditions c JOIN ConditionPlatforms d ON d.condition_id = c.id
+				WHERE c.ad_id = a.id AND d.platform = %s)`, search.Platform)
+	}
+	return where
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so they match literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// SearchAds returns a page of the ads matching the search, and the total amount of matching ads.
+// The ids of the page are selected first, since paginating the ads joined with their conditions would split ads across pages.
+func (db database) SearchAds(ctx context.Context, search AdSearch) ([]models.Ad, int, error) {
+	defer metrics.ObserveQuery("search_ads", time.Now())
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	where := search.where()
+
+	var total int
+	err := db.inner.QueryRowContext(ctx, "SELECT COUNT(*) FROM Ads a WHERE "+where.String(), where.args...).Scan(&total)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not count searched ads", zap.Error(err))
+		return nil, 0, err
+	}
+
+	sort := search.Sort
+	if sort == "" {
+		sort = SortByStartAt
+	}
+	direction := "ASC"
+	if search.Descending {
+		direction = "DESC"
+	}
+	args := append(slices.Clone(where.args), search.Limit, search.Offset)
+	rows, err := db.inner.QueryContext(ctx, fmt.Sprintf("SELECT a.id FROM Ads a WHERE %s ORDER BY a.%s %s, a.id %s LIMIT $%d OFFSET $%d",
+		where, sort, direction, direction, len(args)-1, len(args)), args...)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query searched ads", zap.Error(err))
+		return nil, 0, err
+	}
+	var ids []any
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.Log(zap.ErrorLevel, "error scanning rows", zap.Error(err))
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return []models.Ad{}, total, nil
+	}
+
+	page := &whereBuilder{}
+	page.add("a.id IN ("+strings.TrimSuffix(strings.Repeat("%s, ", len(ids)), ", ")+")", ids...)
+	ads, err := queryAds(ctx, db.inner, page.String(), page.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	//queryAds sorts by end time, so the ads are put back in the order of the page
+	position := make(map[uuid.UUID]int, len(ids))
+	for i, id := range ids {
+		position[id.(uuid.UUID)] = i
+	}
+	slices.SortFunc(ads, func(a, b models.Ad) int {
+		return position[a.ID] - position[b.ID]
+	})
+	return ads, total, nil
+}
@@ -0,0 +1,58 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func (db database) InsertSegment(ctx context.Context, segment models.Segment) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	_, err := db.inner.ExecContext(ctx, "INSERT INTO Segments (id, name, size, updated_at) VALUES ($1, $2, $3, $4)",
+		segment.ID, segment.Name, segment.Size, segment.UpdatedAt)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for insert segment", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (db database) GetSegment(ctx context.Context, id uuid.UUID) (models.Segment, error) {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	segment := models.Segment{}
+	err := db.inner.QueryRowContext(ctx, "SELECT id, name, size, updated_at FROM Segments WHERE id = $1", id).
+		Scan(&segment.ID, &segment.Name, &segment.Size, &segment.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Segment{}, ErrSegmentNotFound
+	}
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not query context for get segment", zap.Error(err))
+		return models.Segment{}, err
+	}
+	return segment, nil
+}
+
+func (db database) UpdateSegment(ctx context.Context, segment models.Segment) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	result, err := db.inner.ExecContext(ctx, "UPDATE Segments SET name = $1, size = $2, updated_at = $3 WHERE id = $4",
+		segment.Name, segment.Size, segment.UpdatedAt, segment.ID)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for update segment", zap.Error(err))
+		return err
+	}
+	return expectAffected(result, ErrSegmentNotFound)
+}
+
+func (db database) DeleteSegment(ctx context.Context, id uuid.UUID) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	result, err := db.inner.ExecContext(ctx, "DELETE FROM Segments WHERE id = $1", id)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for delete segment", zap.Error(err))
+		return err
+	}
+	return expectAffected(result, ErrSegmentNotFound)
+}
@@ -0,0 +1,26 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func (db database) SetAdStatus(ctx context.Context, id uuid.UUID, status models.Status) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	result, err := db.inner.ExecContext(ctx, "UPDATE Ads SET status = $1 WHERE id = $2", status, id)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for set ad status", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAdNotFound
+	}
+	return nil
+}
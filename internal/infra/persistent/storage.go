@@ -4,6 +4,7 @@ import (
 	"advertise_service/internal/infra/logging"
 	"advertise_service/internal/models"
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -11,9 +12,76 @@ import (
 	"time"
 )
 
+var (
+	// ErrAdNotFound is returned when the requested ad doesn't exist
+	ErrAdNotFound = errors.New("ad not found")
+	// ErrAdvertiserNotFound is returned when the requested advertiser doesn't exist
+	ErrAdvertiserNotFound = errors.New("advertiser not found")
+	// ErrCampaignNotFound is returned when the requested campaign doesn't exist
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrAdvertiserHasCampaigns is returned when deleting an advertiser that still owns campaigns
+	ErrAdvertiserHasCampaigns = errors.New("advertiser still has campaigns")
+	// ErrCampaignHasAds is returned when deleting a campaign that still has ads
+	ErrCampaignHasAds = errors.New("campaign still has ads")
+	// ErrSegmentNotFound is returned when the requested segment doesn't exist
+	ErrSegmentNotFound = errors.New("segment not found")
+)
+
 type Storage interface {
 	InsertAd(ctx context.Context, ad models.Ad) error
+	// InsertAds inserts the ads in one transaction, if any of them fails none are stored and an InsertAdsError is returned
+	InsertAds(ctx context.Context, ads []models.Ad) error
+	// FindAdsWithTime returns the active ads within the time range, ads of a campaign are only returned while the campaign is active,
+	// with their start and end time narrowed to the window of the campaign
 	FindAdsWithTime(ctx context.Context, startBefore time.Time, endAfter time.Time) ([]models.Ad, error)
+	// GetAd returns the ad with its conditions, or ErrAdNotFound
+	GetAd(ctx context.Context, id uuid.UUID) (models.Ad, error)
+	// UpdateAd overwrites an existing ad including its conditions except for its status, or returns ErrAdNotFound
+	UpdateAd(ctx context.Context, ad models.Ad) error
+	// DeleteAd removes an ad and its conditions, or returns ErrAdNotFound
+	DeleteAd(ctx context.Context, id uuid.UUID) error
+	// SetAdStatus changes the status of an ad, or returns ErrAdNotFound
+	SetAdStatus(ctx context.Context, id uuid.UUID, status models.Status) error
+	// SearchAds returns a page of all stored ads matching the search, together with the amount of matching ads.
+	// Unlike FindAdsWithTime it includes paused and ended ads, and doesn't narrow ads to their campaign.
+	SearchAds(ctx context.Context, search AdSearch) ([]models.Ad, int, error)
+
+	// InsertEvents stores a batch of impressions and clicks
+	InsertEvents(ctx context.Context, events []models.Event) error
+	// ReportEvents counts the events of an ad per day, gender, country and platform, from and to are inclusive days in UTC
+	ReportEvents(ctx context.Context, adID uuid.UUID, from time.Time, to time.Time) ([]models.EventReportRow, error)
+
+	InsertAdvertiser(ctx context.Context, advertiser models.Advertiser) error
+	// GetAdvertiser returns the advertiser, or ErrAdvertiserNotFound
+	GetAdvertiser(ctx context.Context, id uuid.UUID) (models.Advertiser, error)
+	ListAdvertisers(ctx context.Context) ([]models.Advertiser, error)
+	// UpdateAdvertiser overwrites an existing advertiser, or returns ErrAdvertiserNotFound
+	UpdateAdvertiser(ctx context.Context, advertiser models.Advertiser) error
+	// DeleteAdvertiser removes an advertiser without campaigns, or returns ErrAdvertiserNotFound or ErrAdvertiserHasCampaigns
+	DeleteAdvertiser(ctx context.Context, id uuid.UUID) error
+
+	InsertCampaign(ctx context.Context, campaign models.Campaign) error
+	// GetCampaign returns the campaign, or ErrCampaignNotFound
+	GetCampaign(ctx context.Context, id uuid.UUID) (models.Campaign, error)
+	// ListCampaigns returns the campaigns of an advertiser sorted by start time
+	ListCampaigns(ctx context.Context, advertiserID uuid.UUID) ([]models.Campaign, error)
+	// UpdateCampaign overwrites the name, window and status of an existing campaign, or returns ErrCampaignNotFound
+	UpdateCampaign(ctx context.Context, campaign models.Campaign) error
+	// DeleteCampaign removes a campaign without ads, or returns ErrCampaignNotFound or ErrCampaignHasAds
+	DeleteCampaign(ctx context.Context, id uuid.UUID) error
+	// FindAdIDsByCampaign returns the ids of all ads in the campaign regardless of their status
+	FindAdIDsByCampaign(ctx context.Context, campaignID uuid.UUID) ([]uuid.UUID, error)
+
+	InsertSegment(ctx context.Context, segment models.Segment) error
+	// GetSegment returns the segment, or ErrSegmentNotFound
+	GetSegment(ctx context.Context, id uuid.UUID) (models.Segment, error)
+	// UpdateSegment overwrites the name, size and update time of an existing segment, or returns ErrSegmentNotFound
+	UpdateSegment(ctx context.Context, segment models.Segment) error
+	// DeleteSegment removes a segment, or returns ErrSegmentNotFound. Ads still referencing it no longer match anyone
+	DeleteSegment(ctx context.Context, id uuid.UUID) error
+
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
 }
 
 func TestStorage(t *testing.T, db Storage) {
@@ -39,6 +107,10 @@ func TestStorage(t *testing.T, db Storage) {
 		EndAt:   now.Add(-time.Hour),
 	}
 
+	t.Run("Ping", func(t *testing.T) {
+		require.NoError(t, db.Ping(ctx))
+	})
+
 	t.Run("InsertAd", func(t *testing.T) {
 		err := db.InsertAd(ctx, ad)
 		require.NoError(t, err)
@@ -53,4 +125,324 @@ func TestStorage(t *testing.T, db Storage) {
 		require.Equal(t, ad.Title, ads[0].Title)
 	})
 
+	t.Run("GetAd", func(t *testing.T) {
+		found, err := db.GetAd(ctx, ad.ID)
+		require.NoError(t, err)
+		require.Equal(t, ad.Title, found.Title)
+		require.Equal(t, models.StatusActive, found.Status)
+		require.Nil(t, found.FrequencyCap)
+		require.Nil(t, found.Budget)
+		require.Len(t, found.Conditions, 1)
+		require.Equal(t, 20, found.Conditions[0].AgeStart)
+
+		_, err = db.GetAd(ctx, uuid.New())
+		require.ErrorIs(t, err, ErrAdNotFound)
+	})
+
+	t.Run("InsertAdAtomic", func(t *testing.T) {
+		// the second condition repeats a country, so the insert fails after the ad row was written
+		partial := models.Ad{
+			ID:      uuid.New(),
+			Title:   "partial",
+			StartAt: now.Add(-time.Hour),
+			EndAt:   now.Add(time.Hour),
+			Conditions: []models.Condition{
+				{Country: []models.Country{models.Taiwan}},
+				{Country: []models.Country{models.Japan, models.Japan}},
+			},
+		}
+		require.Error(t, db.InsertAd(ctx, partial))
+		_, err := db.GetAd(ctx, partial.ID)
+		require.ErrorIs(t, err, ErrAdNotFound)
+	})
+
+	t.Run("InsertAds", func(t *testing.T) {
+		batch := []models.Ad{
+			{ID: uuid.New(), Title: "batch 1", StartAt: now.Add(-time.Hour), EndAt: now.Add(-time.Minute),
+				Conditions: []models.Condition{{Country: []models.Country{models.Taiwan}}}},
+			{ID: uuid.New(), Title: "batch 2", StartAt: now.Add(-time.Hour), EndAt: now.Add(-time.Minute)},
+		}
+		require.NoError(t, db.InsertAds(ctx, batch))
+		for _, ad := range batch {
+			found, err := db.GetAd(ctx, ad.ID)
+			require.NoError(t, err)
+			require.Equal(t, ad.Conditions, found.Conditions)
+			require.NoError(t, db.DeleteAd(ctx, ad.ID))
+		}
+
+		//the second ad repeats the id of the first, so neither of them is stored
+		duplicated := []models.Ad{
+			{ID: uuid.New(), Title: "first", StartAt: now.Add(-time.Hour), EndAt: now.Add(-time.Minute)},
+		}
+		duplicated = append(duplicated, duplicated[0])
+		err := db.InsertAds(ctx, duplicated)
+		var insertErr InsertAdsError
+		require.ErrorAs(t, err, &insertErr)
+		require.Equal(t, 1, insertErr.Index)
+		_, err = db.GetAd(ctx, duplicated[0].ID)
+		require.ErrorIs(t, err, ErrAdNotFound)
+	})
+
+	t.Run("UpdateAd", func(t *testing.T) {
+		updated := ad
+		updated.Title = "updated"
+		updated.EndAt = now.Add(30 * time.Minute)
+		updated.Conditions = []models.Condition{
+			{AgeStart: 30, AgeEnd: 40, Country: []models.Country{models.Japan, models.HongKong, "US"}},
+			{Platform: []models.Platform{models.Web, models.Ios}, Gender: []models.Gender{models.Female}},
+		}
+		updated.FrequencyCap = &models.FrequencyCap{Max: 3, WindowSeconds: 86400}
+		updated.Budget = &models.Budget{Total: 10000, Daily: 2000, ImpressionCost: 5}
+		updated.Schedule = &models.Schedule{
+			Timezone: "Asia/Taipei",
+			Windows:  []models.ScheduleWindow{{Days: []time.Weekday{time.Saturday, time.Sunday}, Start: "11:00", End: "14:00"}},
+		}
+		updated.Targeting = &models.Expression{And: []models.Expression{
+			{Field: models.FieldCountry, Op: models.OpIn, Values: []string{"TW"}},
+			{Not: &models.Expression{Field: models.FieldPlatform, Op: models.OpIn, Values: []string{"ios"}}},
+		}}
+		updated.Priority = 3
+		updated.Weight = 7
+		//the status is only changed by SetAdStatus
+		updated.Status = models.StatusPaused
+		updated.Creative = models.Creative{
+			Description:  "description",
+			ImageURL:     "https://example.com/image.png",
+			ClickURL:     "https://example.com",
+			CallToAction: "Shop now",
+		}
+		require.NoError(t, db.UpdateAd(ctx, updated))
+
+		found, err := db.GetAd(ctx, ad.ID)
+		require.NoError(t, err)
+		require.Equal(t, "updated", found.Title)
+		require.WithinDuration(t, updated.EndAt, found.EndAt, time.Second)
+		require.Equal(t, updated.Conditions, found.Conditions)
+		require.Equal(t, updated.FrequencyCap, found.FrequencyCap)
+		require.Equal(t, updated.Budget, found.Budget)
+		require.Equal(t, 3, found.Priority)
+		require.Equal(t, 7, found.Weight)
+		require.Equal(t, updated.Creative, found.Creative)
+		require.Equal(t, updated.Schedule, found.Schedule)
+		require.Equal(t, updated.Targeting, found.Targeting)
+		require.Equal(t, models.StatusActive, found.Status)
+
+		updated.ID = uuid.New()
+		require.ErrorIs(t, db.UpdateAd(ctx, updated), ErrAdNotFound)
+	})
+
+	t.Run("SetAdStatus", func(t *testing.T) {
+		require.NoError(t, db.SetAdStatus(ctx, ad.ID, models.StatusPaused))
+		found, err := db.GetAd(ctx, ad.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.StatusPaused, found.Status)
+
+		//paused ads are not active
+		ads, err := db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 0)
+
+		require.NoError(t, db.SetAdStatus(ctx, ad.ID, models.StatusActive))
+		ads, err = db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 1)
+
+		require.ErrorIs(t, db.SetAdStatus(ctx, uuid.New(), models.StatusPaused), ErrAdNotFound)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		params := models.ConditionParams{Age: 24, Gender: models.Female, Country: models.Taiwan, Platform: models.Ios}
+		yesterday := now.Add(-24 * time.Hour)
+		var events []models.Event
+		for i := range 3 {
+			events = append(events, models.Event{ID: uuid.New(), AdID: ad.ID, Kind: models.Impression, OccurredAt: now, Params: params})
+			if i == 0 {
+				events = append(events, models.Event{ID: uuid.New(), AdID: ad.ID, Kind: models.Click, OccurredAt: now, Params: params})
+			}
+		}
+		events = append(events, models.Event{ID: uuid.New(), AdID: ad.ID, Kind: models.Impression, OccurredAt: yesterday, Params: params})
+		events = append(events, models.Event{ID: uuid.New(), AdID: ad2.ID, Kind: models.Impression, OccurredAt: now, Params: params})
+		require.NoError(t, db.InsertEvents(ctx, events))
+
+		report, err := db.ReportEvents(ctx, ad.ID, yesterday, now)
+		require.NoError(t, err)
+		require.Len(t, report, 2)
+		require.Equal(t, yesterday.Truncate(24*time.Hour), report[0].Day)
+		require.Equal(t, int64(1), report[0].Impressions)
+		require.Equal(t, int64(0), report[0].Clicks)
+		require.Equal(t, now.Truncate(24*time.Hour), report[1].Day)
+		require.Equal(t, int64(3), report[1].Impressions)
+		require.Equal(t, int64(1), report[1].Clicks)
+		require.Equal(t, models.Taiwan, report[1].Country)
+
+		report, err = db.ReportEvents(ctx, ad.ID, now, now)
+		require.NoError(t, err)
+		require.Len(t, report, 1)
+	})
+
+	t.Run("DeleteAd", func(t *testing.T) {
+		require.NoError(t, db.DeleteAd(ctx, ad.ID))
+		_, err := db.GetAd(ctx, ad.ID)
+		require.ErrorIs(t, err, ErrAdNotFound)
+		require.ErrorIs(t, db.DeleteAd(ctx, ad.ID), ErrAdNotFound)
+
+		ads, err := db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 0)
+	})
+
+	t.Run("Campaigns", func(t *testing.T) {
+		advertiser := models.Advertiser{ID: uuid.New(), Name: "advertiser"}
+		require.NoError(t, db.InsertAdvertiser(ctx, advertiser))
+		advertiser.Name = "renamed"
+		require.NoError(t, db.UpdateAdvertiser(ctx, advertiser))
+		foundAdvertiser, err := db.GetAdvertiser(ctx, advertiser.ID)
+		require.NoError(t, err)
+		require.Equal(t, "renamed", foundAdvertiser.Name)
+		_, err = db.GetAdvertiser(ctx, uuid.New())
+		require.ErrorIs(t, err, ErrAdvertiserNotFound)
+
+		campaign := models.Campaign{
+			ID:           uuid.New(),
+			AdvertiserID: advertiser.ID,
+			Name:         "campaign",
+			StartAt:      now.Add(-30 * time.Minute),
+			EndAt:        now.Add(30 * time.Minute),
+		}
+		require.NoError(t, db.InsertCampaign(ctx, campaign))
+		campaigns, err := db.ListCampaigns(ctx, advertiser.ID)
+		require.NoError(t, err)
+		require.Len(t, campaigns, 1)
+		require.Equal(t, models.StatusActive, campaigns[0].Status)
+		require.ErrorIs(t, db.DeleteAdvertiser(ctx, advertiser.ID), ErrAdvertiserHasCampaigns)
+
+		inCampaign := models.Ad{
+			ID:         uuid.New(),
+			Title:      "in campaign",
+			StartAt:    now.Add(-time.Hour),
+			EndAt:      now.Add(time.Hour),
+			CampaignID: &campaign.ID,
+		}
+		require.NoError(t, db.InsertAd(ctx, inCampaign))
+		found, err := db.GetAd(ctx, inCampaign.ID)
+		require.NoError(t, err)
+		require.Equal(t, campaign.ID, *found.CampaignID)
+		ids, err := db.FindAdIDsByCampaign(ctx, campaign.ID)
+		require.NoError(t, err)
+		require.Equal(t, []uuid.UUID{inCampaign.ID}, ids)
+
+		//the ad is narrowed to the window of its campaign
+		ads, err := db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 1)
+		require.WithinDuration(t, campaign.EndAt, ads[0].EndAt, time.Second)
+		require.WithinDuration(t, campaign.StartAt, ads[0].StartAt, time.Second)
+
+		//ads of a paused or ended campaign are not active
+		campaign.Status = models.StatusPaused
+		require.NoError(t, db.UpdateCampaign(ctx, campaign))
+		ads, err = db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 0)
+
+		campaign.Status = models.StatusActive
+		campaign.EndAt = now.Add(-time.Minute)
+		require.NoError(t, db.UpdateCampaign(ctx, campaign))
+		ads, err = db.FindAdsWithTime(ctx, now, now)
+		require.NoError(t, err)
+		require.Len(t, ads, 0)
+
+		require.ErrorIs(t, db.DeleteCampaign(ctx, campaign.ID), ErrCampaignHasAds)
+		require.NoError(t, db.DeleteAd(ctx, inCampaign.ID))
+		require.NoError(t, db.DeleteCampaign(ctx, campaign.ID))
+		require.ErrorIs(t, db.DeleteCampaign(ctx, campaign.ID), ErrCampaignNotFound)
+		require.NoError(t, db.DeleteAdvertiser(ctx, advertiser.ID))
+	})
+
+	t.Run("SearchAds", func(t *testing.T) {
+		searched := []models.Ad{
+			{ID: uuid.New(), Title: "Search summer sale", StartAt: now.Add(-3 * time.Hour), EndAt: now.Add(time.Hour),
+				Conditions: []models.Condition{{Country: []models.Country{models.Taiwan}, Platform: []models.Platform{models.Ios}}}},
+			{ID: uuid.New(), Title: "search winter sale", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour),
+				Conditions: []models.Condition{{Country: []models.Country{models.Japan}}, {Country: []models.Country{models.Taiwan}}}},
+			{ID: uuid.New(), Title: "search 100% off", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+		}
+		for _, ad := range searched {
+			require.NoError(t, db.InsertAd(ctx, ad))
+		}
+		require.NoError(t, db.SetAdStatus(ctx, searched[1].ID, models.StatusPaused))
+		search := func(search AdSearch) ([]string, int) {
+			if search.Limit == 0 {
+				search.Limit = 10
+			}
+			ads, total, err := db.SearchAds(ctx, search)
+			require.NoError(t, err)
+			titles := make([]string, len(ads))
+			for i, ad := range ads {
+				titles[i] = ad.Title
+			}
+			return titles, total
+		}
+
+		titles, total := search(AdSearch{Title: "SEARCH"})
+		require.Equal(t, []string{"Search summer sale", "search winter sale", "search 100% off"}, titles)
+		require.Equal(t, 3, total)
+		titles, _ = search(AdSearch{Title: "search", Sort: SortByEndAt, Descending: true})
+		require.Equal(t, []string{"search 100% off", "Search summer sale", "search winter sale"}, titles)
+		titles, _ = search(AdSearch{Title: "100%"})
+		require.Equal(t, []string{"search 100% off"}, titles)
+		titles, _ = search(AdSearch{Title: "search", Status: models.StatusPaused})
+		require.Equal(t, []string{"search winter sale"}, titles)
+		titles, _ = search(AdSearch{Title: "search", StartFrom: now.Add(-150 * time.Minute), EndFrom: now})
+		require.Equal(t, []string{"search 100% off"}, titles)
+		titles, _ = search(AdSearch{Title: "search", StartTo: now.Add(-2 * time.Hour), EndTo: now})
+		require.Equal(t, []string{"search winter sale"}, titles)
+		titles, _ = search(AdSearch{Title: "search", Country: models.Taiwan})
+		require.Equal(t, []string{"Search summer sale", "search winter sale"}, titles)
+		titles, _ = search(AdSearch{Title: "search", Country: models.Taiwan, Platform: models.Ios})
+		require.Equal(t, []string{"Search summer sale"}, titles)
+
+		//the total counts every matching ad, not only the page
+		titles, total = search(AdSearch{Title: "search", Sort: SortByTitle, Limit: 2, Offset: 1})
+		require.Equal(t, 3, total)
+		require.Len(t, titles, 2)
+		ads, _, err := db.SearchAds(ctx, AdSearch{Title: "summer", Limit: 1})
+		require.NoError(t, err)
+		require.Equal(t, searched[0].Conditions, ads[0].Conditions)
+
+		for _, ad := range searched {
+			require.NoError(t, db.DeleteAd(ctx, ad.ID))
+		}
+	})
+
+	t.Run("Segments", func(t *testing.T) {
+		segment := models.Segment{ID: uuid.New(), Name: "makeup board visitors", Size: 2, UpdatedAt: now.Truncate(time.Second)}
+		require.NoError(t, db.InsertSegment(ctx, segment))
+		segment.Size = 3
+		require.NoError(t, db.UpdateSegment(ctx, segment))
+		found, err := db.GetSegment(ctx, segment.ID)
+		require.NoError(t, err)
+		require.Equal(t, segment.Name, found.Name)
+		require.Equal(t, 3, found.Size)
+		require.WithinDuration(t, segment.UpdatedAt, found.UpdatedAt, time.Second)
+
+		retargeted := models.Ad{
+			ID:         uuid.New(),
+			Title:      "retargeted",
+			StartAt:    now.Add(-time.Hour),
+			EndAt:      now.Add(time.Hour),
+			Conditions: []models.Condition{{Country: []models.Country{models.Taiwan}, Segments: []uuid.UUID{segment.ID}}},
+		}
+		require.NoError(t, db.InsertAd(ctx, retargeted))
+		foundAd, err := db.GetAd(ctx, retargeted.ID)
+		require.NoError(t, err)
+		require.Equal(t, retargeted.Conditions, foundAd.Conditions)
+		require.NoError(t, db.DeleteAd(ctx, retargeted.ID))
+
+		require.NoError(t, db.DeleteSegment(ctx, segment.ID))
+		_, err = db.GetSegment(ctx, segment.ID)
+		require.ErrorIs(t, err, ErrSegmentNotFound)
+		require.ErrorIs(t, db.DeleteSegment(ctx, segment.ID), ErrSegmentNotFound)
+		require.ErrorIs(t, db.UpdateSegment(ctx, segment), ErrSegmentNotFound)
+	})
 }
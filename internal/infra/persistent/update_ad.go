@@ -0,0 +1,58 @@
+package persistent
+
+import (
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/models"
+	"context"
+	"go.uber.org/zap"
+)
+
+// UpdateAd overwrites the ad row and replaces all of its conditions in a single transaction.
+// The status is left as is, it's only changed by SetAdStatus, so pausing the ad while it's being edited isn't reverted.
+func (db database) UpdateAd(ctx context.Context, ad models.Ad) error {
+	logger := ctx.Value(logging.LoggerContextKey{}).(*zap.Logger)
+	tx, err := db.inner.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not begin transaction for update ad", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	capMax, capWindow := frequencyCapColumns(ad.FrequencyCap)
+	budgetTotal, budgetDaily, impressionCost := budgetColumns(ad.Budget)
+	schedule, err := jsonColumn(ad.Schedule)
+	if err != nil {
+		return err
+	}
+	targeting, err := jsonColumn(ad.Targeting)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE Ads SET title = $1, start_at = $2, end_at = $3, campaign_id = $4, frequency_cap_max = $5, frequency_cap_window = $6,
+		budget_total = $7, budget_daily = $8, budget_impression_cost = $9, priority = $10, weight = $11,
+		description = $12, image_url = $13, click_url = $14, call_to_action = $15, schedule = $16, targeting = $17 WHERE id = $18`,
+		ad.Title, ad.StartAt, ad.EndAt, campaignColumn(ad.CampaignID), capMax, capWindow,
+		budgetTotal, budgetDaily, impressionCost, ad.Priority, ad.Weight,
+		ad.Description, ad.ImageURL, ad.ClickURL, ad.CallToAction, schedule, targeting, ad.ID)
+	if err != nil {
+		logger.Log(zap.ErrorLevel, "Could not execute context for update ad", zap.Error(err))
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAdNotFound
+	}
+
+	err = deleteConditions(ctx, tx, ad.ID)
+	if err != nil {
+		return err
+	}
+	err = insertConditions(ctx, tx, ad.ID, ad.Conditions)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
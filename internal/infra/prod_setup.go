@@ -3,20 +3,62 @@ package infra
 import (
 	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/infra/tracing"
+	"advertise_service/internal/models"
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"log"
+	"strings"
 )
 
-func ProductionSetup(config Config) (persistent.Storage, cache.Service) {
+// ProductionSetup connects to postgres and redis and migrates the database.
+// The returned close function releases both connections, it must only be called after the server stopped using them.
+func ProductionSetup(config Config) (persistent.Storage, cache.Service, func() error) {
+	registerCatalogs(config)
+	redisClient := openRedis(config)
+	db := OpenDatabase(config)
+	applied, err := persistent.MigrateUp(context.Background(), db)
+	if err != nil {
+		panic(err)
+	}
+	log.Print("Applied migrations ", applied)
+
+	closeConnections := func() error {
+		return errors.Join(redisClient.Close(), db.Close())
+	}
+	return persistent.NewSQLDatabase(db), cache.NewRedisCacheService(redisClient), closeConnections
+}
+
+// CommandSetup connects to postgres and redis for commands running next to the servers.
+// Unlike ProductionSetup it doesn't migrate the database nor reset the cache the servers are using.
+func CommandSetup(config Config) (persistent.Storage, cache.Service, func() error) {
+	registerCatalogs(config)
+	redisClient := openRedis(config)
+	db := OpenDatabase(config)
+	closeConnections := func() error {
+		return errors.Join(redisClient.Close(), db.Close())
+	}
+	return persistent.NewSQLDatabase(db), cache.AttachRedisCacheService(redisClient), closeConnections
+}
+
+func openRedis(config Config) *redis.Client {
 	opt, err := redis.ParseURL(config.RedisURI)
 	if err != nil {
 		panic(err)
 	}
 	redisClient := redis.NewClient(opt)
+	tracing.InstrumentRedis(redisClient)
+	return redisClient
+}
 
-	db, err := sql.Open("pgx", config.PostgresURI)
+// OpenDatabase connects to postgres and panics if it is unreachable, every query is traced
+func OpenDatabase(config Config) *sql.DB {
+	db, err := otelsql.Open("pgx", config.PostgresURI, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		panic(err)
 	}
@@ -24,11 +66,24 @@ func ProductionSetup(config Config) (persistent.Storage, cache.Service) {
 	if err != nil {
 		panic(err)
 	}
+	return db
+}
 
-	if config.AutoMigration {
-		log.Print("Running auto migration")
-		persistent.CreateTables(db)
+// registerCatalogs makes the configured extra targeting values and custom attributes valid, a malformed attribute panics
+func registerCatalogs(config Config) {
+	for _, country := range config.ExtraCountries {
+		models.RegisterCountry(models.Country(country))
+	}
+	for _, platform := range config.ExtraPlatforms {
+		models.RegisterPlatform(models.Platform(platform))
+	}
+	for _, gender := range config.ExtraGenders {
+		models.RegisterGender(gender)
+	}
+	for _, attribute := range config.ExtraAttributes {
+		key, attributeType, _ := strings.Cut(attribute, ":")
+		if err := models.RegisterAttribute(key, models.AttributeType(attributeType)); err != nil {
+			panic(err)
+		}
 	}
-
-	return persistent.NewSQLDatabase(db), cache.NewRedisCacheService(redisClient)
 }
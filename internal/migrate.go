@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"advertise_service/internal/infra"
+	"advertise_service/internal/infra/persistent"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: migrate up | migrate down [steps] | migrate status"
+
+// MigrateCommand runs `migrate up|down|status` against the configured postgres and writes the result to out.
+// down reverts one migration unless a number of steps is given.
+func MigrateCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	ctx := context.Background()
+	db := infra.OpenDatabase(infra.LoadDatabaseConfig())
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := persistent.MigrateUp(ctx, db)
+		for _, version := range applied {
+			fmt.Fprintln(out, "applied", version)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+		reverted, err := persistent.MigrateDown(ctx, db, steps)
+		for _, version := range reverted {
+			fmt.Fprintln(out, "reverted", version)
+		}
+		return err
+	case "status":
+		statuses, err := persistent.GetMigrationStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%04d %-30s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -5,8 +5,8 @@ package mock
 import (
 	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/models"
-	"cmp"
 	"context"
+	"github.com/google/uuid"
 	"slices"
 	"time"
 )
@@ -17,68 +17,220 @@ type mockCache struct {
 
 func (c mockCache) Clear(ctx context.Context) error {
 	c.inner.ads = []models.Ad{}
+	c.inner.lastUpdate = time.Time{}
+	c.inner.version++
 	return nil
 }
 
 type cacheArray struct {
-	ads []models.Ad
+	ads        []models.Ad
+	lastUpdate time.Time
+	version    int64
+	frequency  map[string]frequencyCounter
+	// spent is the total spend of each budgeted ad, dailySpent the spend per ad and UTC day
+	spent      map[uuid.UUID]int64
+	dailySpent map[string]int64
+	segments   map[uuid.UUID]map[string]bool
+	// clicks are the impressions whose click was recorded, until their token expires
+	clicks map[uuid.UUID]time.Time
+}
+
+type frequencyCounter struct {
+	count     int
+	expiresAt time.Time
 }
 
 func NewCache() cache.Service {
 	return mockCache{
-		inner: &cacheArray{},
+		inner: &cacheArray{
+			frequency:  map[string]frequencyCounter{},
+			spent:      map[uuid.UUID]int64{},
+			dailySpent: map[string]int64{},
+			segments:   map[uuid.UUID]map[string]bool{},
+			clicks:     map[uuid.UUID]time.Time{},
+		},
 	}
 }
 
 func (c mockCache) CheckCacheValid(ctx context.Context) (bool, error) {
-	return len(c.inner.ads) > 0, nil
+	return len(c.inner.ads) > 0 || time.Since(c.inner.lastUpdate) < cache.Interval, nil
+}
+
+func (c mockCache) Version(ctx context.Context) (int64, error) {
+	return c.inner.version, nil
+}
+
+func (c mockCache) Ping(ctx context.Context) error {
+	return nil
 }
 
-// GetActiveAds retrieves active ads with params skip and count in a sorted list.
-func (c mockCache) GetActiveAds(ctx context.Context, skip int, count int) ([]models.Ad, error) {
-	l := len(c.inner.ads)
-	return c.inner.ads[min(l, skip):min(l, skip+count)], nil
+func (c mockCache) LastUpdate(ctx context.Context) (time.Time, error) {
+	return c.inner.lastUpdate, nil
+}
+
+// GetActiveAds retrieves up to count ads after the cursor in a sorted list.
+func (c mockCache) GetActiveAds(ctx context.Context, after cache.Cursor, count int) ([]models.Ad, error) {
+	ads := make([]models.Ad, 0)
+	for _, ad := range c.inner.ads {
+		if len(ads) == count {
+			break
+		}
+		if after.Before(ad) {
+			ads = append(ads, ad)
+		}
+	}
+	return ads, nil
 }
 
 // WriteActiveAd stores an active ad into the mockCache
 func (c mockCache) WriteActiveAd(ctx context.Context, ad models.Ad) error {
 	c.inner.ads = append(c.inner.ads, ad)
-	slices.SortFunc(c.inner.ads, func(a, b models.Ad) int {
-		if a.EndAt.After(b.EndAt) {
-			return 1
-		} else {
-			return -1
-		}
-	})
+	slices.SortFunc(c.inner.ads, cache.CompareAds)
+	c.inner.version++
 	return nil
 }
 
-// Update stores multiple active ads into mockCache
-func (c mockCache) Update(ctx context.Context, ad []models.Ad) (int, error) {
+// WriteActiveAds stores the ads into the mockCache, replacing the entries of the same ads
+func (c mockCache) WriteActiveAds(ctx context.Context, ads []models.Ad) error {
+	if len(ads) == 0 {
+		return nil
+	}
+	for _, ad := range ads {
+		c.inner.ads = slices.DeleteFunc(c.inner.ads, func(a models.Ad) bool {
+			return a.ID == ad.ID
+		})
+		c.inner.ads = append(c.inner.ads, ad)
+	}
+	slices.SortFunc(c.inner.ads, cache.CompareAds)
+	c.inner.version++
+	return nil
+}
+
+// RemoveActiveAd removes an ad from the mockCache
+func (c mockCache) RemoveActiveAd(ctx context.Context, id uuid.UUID) error {
+	before := len(c.inner.ads)
 	c.inner.ads = slices.DeleteFunc(c.inner.ads, func(a models.Ad) bool {
-		return a.EndAt.Before(time.Now().UTC())
+		return a.ID == id
 	})
+	if len(c.inner.ads) != before {
+		c.inner.version++
+	}
+	return nil
+}
 
-	var largestStart time.Time = time.Unix(0, 0).UTC()
+func (c mockCache) GetImpressionCounts(ctx context.Context, viewer string, adIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	counts := map[uuid.UUID]int{}
+	for _, id := range adIDs {
+		counter, ok := c.inner.frequency[viewer+":"+id.String()]
+		if ok && time.Now().Before(counter.expiresAt) {
+			counts[id] = counter.count
+		}
+	}
+	return counts, nil
+}
 
-	if len(c.inner.ads) != 0 {
-		largestStart = slices.MaxFunc(c.inner.ads, func(a, b models.Ad) int {
-			return cmp.Compare(a.StartAt.Unix(), b.StartAt.Unix())
-		}).StartAt
+func (c mockCache) IncrImpressions(ctx context.Context, viewer string, ads []models.Ad) error {
+	for _, ad := range ads {
+		if ad.FrequencyCap == nil {
+			continue
+		}
+		key := viewer + ":" + ad.ID.String()
+		counter, ok := c.inner.frequency[key]
+		if !ok || !time.Now().Before(counter.expiresAt) {
+			counter = frequencyCounter{expiresAt: time.Now().Add(ad.FrequencyCap.Window())}
+		}
+		counter.count++
+		c.inner.frequency[key] = counter
 	}
+	return nil
+}
 
-	ad = slices.DeleteFunc(ad, func(a models.Ad) bool {
-		return !a.StartAt.After(largestStart)
-	})
+func (c mockCache) ChargeBudgets(ctx context.Context, ads []models.Ad, now time.Time) (map[uuid.UUID]bool, error) {
+	charged := map[uuid.UUID]bool{}
+	for _, ad := range ads {
+		if ad.Budget == nil {
+			continue
+		}
+		cost := ad.Budget.ImpressionCost
+		dayKey := ad.ID.String() + ":" + now.UTC().Format(time.DateOnly)
+		if c.inner.spent[ad.ID]+cost > ad.PacedLimit(now) ||
+			(ad.Budget.Daily > 0 && c.inner.dailySpent[dayKey]+cost > ad.Budget.Daily) {
+			charged[ad.ID] = false
+			continue
+		}
+		c.inner.spent[ad.ID] += cost
+		c.inner.dailySpent[dayKey] += cost
+		charged[ad.ID] = true
+	}
+	return charged, nil
+}
+
+func (c mockCache) ClaimClick(ctx context.Context, impressionID uuid.UUID, expiresAt time.Time) (bool, error) {
+	if expires, ok := c.inner.clicks[impressionID]; ok && time.Now().Before(expires) {
+		return false, nil
+	}
+	c.inner.clicks[impressionID] = expiresAt
+	return true, nil
+}
+
+func (c mockCache) ReplaceSegment(ctx context.Context, id uuid.UUID, members []string) error {
+	set := make(map[string]bool, len(members))
+	for _, member := range members {
+		set[member] = true
+	}
+	c.inner.segments[id] = set
+	return nil
+}
+
+func (c mockCache) DeleteSegment(ctx context.Context, id uuid.UUID) error {
+	delete(c.inner.segments, id)
+	return nil
+}
 
-	c.inner.ads = append(c.inner.ads, ad...)
-	slices.SortFunc(c.inner.ads, func(a, b models.Ad) int {
-		if a.EndAt.After(b.EndAt) {
-			return 1
-		} else {
-			return -1
+func (c mockCache) SegmentMemberships(ctx context.Context, viewer string, segments []uuid.UUID) ([]uuid.UUID, error) {
+	var member []uuid.UUID
+	for _, id := range segments {
+		if c.inner.segments[id][viewer] {
+			member = append(member, id)
 		}
+	}
+	return member, nil
+}
+
+// Update replaces the ads of the mockCache with the given active ads, replacing the ads that changed.
+// Ads that aren't given are only removed if the mockCache didn't change since.
+func (c mockCache) Update(ctx context.Context, ads []models.Ad, since int64) (int, error) {
+	now := time.Now().UTC()
+	before := len(c.inner.ads)
+	prune := c.inner.version == since
+	c.inner.ads = slices.DeleteFunc(c.inner.ads, func(a models.Ad) bool {
+		return a.EndAt.Before(now) || prune && !slices.ContainsFunc(ads, func(fresh models.Ad) bool {
+			return fresh.ID == a.ID && fresh.StartAt.Before(now.Add(cache.Interval+cache.Tolerance))
+		})
 	})
 
-	return len(ad), nil
+	written := 0
+	for _, ad := range ads {
+		if !ad.StartAt.Before(now.Add(cache.Interval + cache.Tolerance)) {
+			continue
+		}
+		i := slices.IndexFunc(c.inner.ads, func(a models.Ad) bool {
+			return a.ID == ad.ID
+		})
+		if i >= 0 {
+			if c.inner.ads[i].String() == ad.String() {
+				continue
+			}
+			c.inner.ads = slices.Delete(c.inner.ads, i, i+1)
+		}
+		c.inner.ads = append(c.inner.ads, ad)
+		written++
+	}
+	slices.SortFunc(c.inner.ads, cache.CompareAds)
+	c.inner.lastUpdate = now
+	if written != 0 || len(c.inner.ads) != before {
+		c.inner.version++
+	}
+
+	return written, nil
 }
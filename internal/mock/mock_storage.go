@@ -9,7 +9,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"time"
 )
 
@@ -21,8 +21,22 @@ type innerStorage struct {
 	ads map[uuid.UUID]models.Ad
 }
 
+// Driver is an sqlite driver with no-op versions of the postgres functions the storage calls, such as the migration lock
+const Driver = "sqlite3_postgres"
+
+func init() {
+	noop := func(key int64) int64 {
+		return 0
+	}
+	sql.Register(Driver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return errors.Join(conn.RegisterFunc("pg_advisory_lock", noop, false), conn.RegisterFunc("pg_advisory_unlock", noop, false))
+		},
+	})
+}
+
 func NewStorage() persistent.Storage {
-	db, err := sql.Open("sqlite3", ":memory:")
+	db, err := sql.Open(Driver, ":memory:")
 	if err != nil {
 		panic(err)
 	}
@@ -30,9 +44,9 @@ func NewStorage() persistent.Storage {
 	if err != nil {
 		panic(err)
 	}
-	persistent.CreateTables(db)
+	_, err = persistent.MigrateUp(context.Background(), db)
 	if err != nil {
-		return nil
+		panic(err)
 	}
 
 	return persistent.NewSQLDatabase(db)
@@ -3,6 +3,8 @@ package mock
 import (
 	"advertise_service/internal/infra/cache"
 	"advertise_service/internal/infra/persistent"
+	"database/sql"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -13,3 +15,9 @@ func TestMockStorage(t *testing.T) {
 func TestMockCache(t *testing.T) {
 	cache.TestCacheService(t, NewCache())
 }
+
+func TestMigrations(t *testing.T) {
+	db, err := sql.Open(Driver, ":memory:")
+	require.NoError(t, err)
+	persistent.TestMigrations(t, db)
+}
@@ -11,12 +11,34 @@ type Ad struct {
 	Title      string      `json:"title"`
 	StartAt    time.Time   `json:"start_at"`
 	EndAt      time.Time   `json:"end_at"`
+	Status     Status      `json:"status"`
 	Conditions []Condition `json:"conditions"`
+	// Targeting is optional, when present it replaces Conditions to decide who the ad is shown to
+	Targeting *Expression `json:"targeting,omitempty"`
+	Creative
+	// CampaignID is optional, ads without a campaign are only constrained by their own status and window
+	CampaignID *uuid.UUID `json:"campaign_id,omitempty"`
+	// FrequencyCap is optional, ads without it can be shown to a viewer any number of times
+	FrequencyCap *FrequencyCap `json:"frequency_cap,omitempty"`
+	// Budget is optional, ads without it are served without being charged
+	Budget *Budget `json:"budget,omitempty"`
+	// Schedule is optional, ads with it are only active within its weekly windows on top of StartAt and EndAt
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// Priority ranks the ad above ads with a lower priority, used by the priority based rankings
+	Priority int `json:"priority,omitempty"`
+	// Weight is the relative share of top slots the ad gets in the weighted rotation, 0 counts as 1
+	Weight int `json:"weight,omitempty"`
 }
 
 func (ad Ad) ShouldShow(params ConditionParams) bool {
-	if !ad.IsActive() {
-		return false
+	return ad.IsEnabled() && ad.IsActive() && ad.MatchTargeting(params)
+}
+
+// MatchTargeting reports if the params match the targeting expression of the ad,
+// or any of its conditions when it has no expression. An ad without either is shown to everyone.
+func (ad Ad) MatchTargeting(params ConditionParams) bool {
+	if ad.Targeting != nil {
+		return ad.Targeting.Eval(params)
 	}
 	if len(ad.Conditions) == 0 {
 		return true
@@ -30,8 +52,20 @@ func (ad Ad) ShouldShow(params ConditionParams) bool {
 }
 
 func (ad Ad) IsActive() bool {
-	now := time.Now().UTC()
-	return now.After(ad.StartAt) && now.Before(ad.EndAt)
+	return ad.IsActiveAt(time.Now().UTC())
+}
+
+// IsActiveAt reports if the ad is active at the given time, within its window and its schedule if it has one
+func (ad Ad) IsActiveAt(now time.Time) bool {
+	if !now.After(ad.StartAt) || !now.Before(ad.EndAt) {
+		return false
+	}
+	return ad.Schedule == nil || ad.Schedule.ActiveAt(now)
+}
+
+// IsEnabled reports if the ad is neither paused nor archived, ads without a status are treated as active.
+func (ad Ad) IsEnabled() bool {
+	return ad.Status == "" || ad.Status == StatusActive
 }
 
 func (ad Ad) String() string {
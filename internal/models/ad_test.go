@@ -22,6 +22,13 @@ func TestShouldShow(t *testing.T) {
 	assert.False(t, ads[4].ShouldShow(params1))
 	assert.True(t, ads[5].ShouldShow(params1))
 	assert.False(t, ads[6].ShouldShow(params1))
+
+	ads[1].Status = StatusPaused
+	assert.False(t, ads[1].ShouldShow(params1))
+	ads[1].Status = StatusArchived
+	assert.False(t, ads[1].ShouldShow(params1))
+	ads[1].Status = StatusActive
+	assert.True(t, ads[1].ShouldShow(params1))
 }
 
 func generateTestAds() []Ad {
@@ -0,0 +1,9 @@
+package models
+
+import "github.com/google/uuid"
+
+// Advertiser owns campaigns, which group ads
+type Advertiser struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// AttributeType decides which values a custom attribute accepts and how expressions compare it
+type AttributeType string
+
+const (
+	// AttributeString values are compared for equality and set membership
+	AttributeString AttributeType = "string"
+	// AttributeSemver values are semantic versions, which can also be ordered
+	AttributeSemver AttributeType = "semver"
+)
+
+const MaxAttributeValueLength = 128
+
+// ReservedAttributeKeys are the query params of the get ads api and the segment field, which can't be used as attribute keys.
+// segment isn't a query param, the segments of a viewer are looked up from the viewer param.
+var ReservedAttributeKeys = []string{"age", "gender", "country", "platform", "segment", "limit", "cursor", "viewer"}
+
+var attributeKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// attributes are the registered custom attributes by key
+var attributes = struct {
+	mu    sync.RWMutex
+	types map[string]AttributeType
+}{types: map[string]AttributeType{}}
+
+// RegisterAttribute allows targeting on a custom attribute, registering a key again changes its type
+func RegisterAttribute(key string, attributeType AttributeType) error {
+	if !attributeKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid attribute key %q", key)
+	}
+	if slices.Contains(ReservedAttributeKeys, key) {
+		return fmt.Errorf("attribute key %q is reserved", key)
+	}
+	if attributeType != AttributeString && attributeType != AttributeSemver {
+		return fmt.Errorf("unknown attribute type %q", attributeType)
+	}
+	attributes.mu.Lock()
+	defer attributes.mu.Unlock()
+	attributes.types[key] = attributeType
+	return nil
+}
+
+// LookupAttribute returns the type of a registered attribute
+func LookupAttribute(key string) (AttributeType, bool) {
+	attributes.mu.RLock()
+	defer attributes.mu.RUnlock()
+	attributeType, ok := attributes.types[key]
+	return attributeType, ok
+}
+
+// ValidAttributeValue reports if the value can be sent for an attribute of the type
+func ValidAttributeValue(attributeType AttributeType, value string) bool {
+	if value == "" || len(value) > MaxAttributeValueLength {
+		return false
+	}
+	if attributeType == AttributeSemver {
+		return ValidSemver(value)
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegisterAttribute(t *testing.T) {
+	assert.NoError(t, RegisterAttribute("board", AttributeString))
+	attributeType, ok := LookupAttribute("board")
+	assert.True(t, ok)
+	assert.Equal(t, AttributeString, attributeType)
+	_, ok = LookupAttribute("unregistered")
+	assert.False(t, ok)
+
+	assert.Error(t, RegisterAttribute("Board", AttributeString))
+	assert.Error(t, RegisterAttribute("", AttributeString))
+	assert.Error(t, RegisterAttribute("country", AttributeString))
+	assert.Error(t, RegisterAttribute("viewer", AttributeString))
+	assert.Error(t, RegisterAttribute("tier", "number"))
+
+	assert.True(t, ValidAttributeValue(AttributeString, "pets"))
+	assert.False(t, ValidAttributeValue(AttributeString, ""))
+	assert.False(t, ValidAttributeValue(AttributeString, string(make([]byte, MaxAttributeValueLength+1))))
+	assert.True(t, ValidAttributeValue(AttributeSemver, "v5.2.1"))
+	assert.False(t, ValidAttributeValue(AttributeSemver, "latest"))
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Budget limits what an advertiser pays for an ad, amounts are in the smallest currency unit
+type Budget struct {
+	// Total is the budget of the whole StartAt to EndAt window
+	Total int64 `json:"total"`
+	// Daily optionally caps the spend of each UTC day, 0 means no daily cap
+	Daily int64 `json:"daily,omitempty"`
+	// ImpressionCost is charged for every served impression
+	ImpressionCost int64 `json:"impressionCost"`
+}
+
+// PacedLimit returns how much of the total budget the ad may have spent by now.
+// The allowance grows linearly from StartAt to EndAt, so the budget is spread evenly over the window
+// instead of being burned as soon as the ad starts. One impression is always allowed so the ad can start serving.
+func (ad Ad) PacedLimit(now time.Time) int64 {
+	budget := ad.Budget
+	duration := ad.EndAt.Sub(ad.StartAt)
+	elapsed := now.Sub(ad.StartAt)
+	if duration <= 0 || elapsed >= duration {
+		return budget.Total
+	}
+	paced := int64(float64(budget.Total) * float64(max(elapsed, 0)) / float64(duration))
+	return min(budget.Total, max(paced, budget.ImpressionCost))
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPacedLimit(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ad := Ad{
+		StartAt: start,
+		EndAt:   start.Add(10 * time.Hour),
+		Budget:  &Budget{Total: 1000, ImpressionCost: 2},
+	}
+
+	//one impression is allowed right away
+	assert.Equal(t, int64(2), ad.PacedLimit(start.Add(-time.Minute)))
+	assert.Equal(t, int64(2), ad.PacedLimit(start))
+	assert.Equal(t, int64(100), ad.PacedLimit(start.Add(time.Hour)))
+	assert.Equal(t, int64(500), ad.PacedLimit(start.Add(5*time.Hour)))
+	assert.Equal(t, int64(1000), ad.PacedLimit(start.Add(10*time.Hour)))
+	assert.Equal(t, int64(1000), ad.PacedLimit(start.Add(11*time.Hour)))
+}
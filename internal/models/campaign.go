@@ -0,0 +1,37 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Campaign groups ads of an advertiser, its status and time window apply to all of its ads
+type Campaign struct {
+	ID           uuid.UUID `json:"id"`
+	AdvertiserID uuid.UUID `json:"advertiser_id"`
+	Name         string    `json:"name"`
+	StartAt      time.Time `json:"start_at"`
+	EndAt        time.Time `json:"end_at"`
+	Status       Status    `json:"status"`
+}
+
+// IsEnabled reports if the campaign is neither paused nor archived
+func (campaign Campaign) IsEnabled() bool {
+	return campaign.Status == "" || campaign.Status == StatusActive
+}
+
+// Constrain narrows the time window of the ad to the window of the campaign.
+// The second return value is false if the campaign doesn't allow the ad to be served at all,
+// because it's disabled or the windows don't overlap.
+func (campaign Campaign) Constrain(ad Ad) (Ad, bool) {
+	if !campaign.IsEnabled() {
+		return ad, false
+	}
+	if campaign.StartAt.After(ad.StartAt) {
+		ad.StartAt = campaign.StartAt
+	}
+	if campaign.EndAt.Before(ad.EndAt) {
+		ad.EndAt = campaign.EndAt
+	}
+	return ad, ad.StartAt.Before(ad.EndAt)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCampaignConstrain(t *testing.T) {
+	now := time.Now().UTC()
+	ad := Ad{StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(2 * time.Hour)}
+	campaign := Campaign{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Status: StatusActive}
+
+	constrained, ok := campaign.Constrain(ad)
+	assert.True(t, ok)
+	assert.Equal(t, campaign.StartAt, constrained.StartAt)
+	assert.Equal(t, campaign.EndAt, constrained.EndAt)
+
+	//a campaign window wider than the ad keeps the ad window
+	campaign.StartAt = now.Add(-3 * time.Hour)
+	campaign.EndAt = now.Add(3 * time.Hour)
+	constrained, ok = campaign.Constrain(ad)
+	assert.True(t, ok)
+	assert.Equal(t, ad.StartAt, constrained.StartAt)
+	assert.Equal(t, ad.EndAt, constrained.EndAt)
+
+	campaign.Status = StatusPaused
+	_, ok = campaign.Constrain(ad)
+	assert.False(t, ok)
+
+	campaign.Status = StatusActive
+	campaign.StartAt = now.Add(3 * time.Hour)
+	campaign.EndAt = now.Add(4 * time.Hour)
+	_, ok = campaign.Constrain(ad)
+	assert.False(t, ok)
+}
@@ -0,0 +1,30 @@
+package models
+
+import "sync"
+
+// catalog is a set of valid targeting values, new values can be registered while serving
+type catalog[T comparable] struct {
+	mu     sync.RWMutex
+	values map[T]struct{}
+}
+
+func newCatalog[T comparable](values ...T) *catalog[T] {
+	c := &catalog[T]{values: make(map[T]struct{}, len(values))}
+	for _, value := range values {
+		c.values[value] = struct{}{}
+	}
+	return c
+}
+
+func (c *catalog[T]) contains(value T) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.values[value]
+	return ok
+}
+
+func (c *catalog[T]) add(value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value] = struct{}{}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"github.com/google/uuid"
 	"slices"
 )
 
@@ -11,6 +12,8 @@ type Condition struct {
 	Country  []Country  `json:"country"`
 	Gender   []Gender   `json:"gender"`
 	Platform []Platform `json:"platform"`
+	// Segments restricts the condition to viewers in any of the segments
+	Segments []uuid.UUID `json:"segments,omitempty"`
 }
 
 type ConditionParams struct {
@@ -18,10 +21,15 @@ type ConditionParams struct {
 	Gender   Gender   `json:"gender"`
 	Country  Country  `json:"country"`
 	Platform Platform `json:"platform"`
+	// Attributes are the registered custom attributes sent by the viewer, only expressions can target them
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Segments are the segments referenced by active ads that the viewer belongs to,
+	// they are looked up by the server and never read from the request
+	Segments []uuid.UUID `json:"segments,omitempty"`
 }
 
 func (c Condition) Match(p ConditionParams) bool {
-	if !((p.Age > c.AgeStart && p.Age < c.AgeEnd) || (c.AgeStart == 0 && c.AgeEnd == 0)) {
+	if !c.MatchAge(p.Age) {
 		return false
 	}
 	if len(c.Platform) > 0 {
@@ -44,9 +52,18 @@ func (c Condition) Match(p ConditionParams) bool {
 			return false
 		}
 	}
+
+	if len(c.Segments) > 0 && !inSegments(p.Segments, c.Segments) {
+		return false
+	}
 	return true
 }
 
+// MatchAge reports if the age is within the condition, a condition without age range matches every age
+func (c Condition) MatchAge(age int) bool {
+	return (age > c.AgeStart && age < c.AgeEnd) || (c.AgeStart == 0 && c.AgeEnd == 0)
+}
+
 func (c Condition) String() string {
 	jStr, _ := json.Marshal(c)
 	return string(jStr)
@@ -0,0 +1,12 @@
+package models
+
+// Creative is what the frontend renders for an ad besides its title, every field is optional
+type Creative struct {
+	Description string `json:"description,omitempty"`
+	// ImageURL is an https url of the image shown with the ad
+	ImageURL string `json:"image_url,omitempty"`
+	// ClickURL is the landing page the viewer is sent to when clicking the ad
+	ClickURL string `json:"click_url,omitempty"`
+	// CallToAction is the label of the button leading to ClickURL, such as "Shop now"
+	CallToAction string `json:"call_to_action,omitempty"`
+}
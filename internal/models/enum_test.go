@@ -2,15 +2,19 @@ package models
 
 import (
 	"github.com/stretchr/testify/assert"
+	"maps"
 	"testing"
 )
 
 func TestValidEnum(t *testing.T) {
 	assert.True(t, ValidCountry("TW"))
 	assert.True(t, ValidCountry("JP"))
-	assert.False(t, ValidCountry("US"))
-	assert.False(t, ValidCountry("CN"))
-	assert.False(t, ValidCountry("KR"))
+	assert.True(t, ValidCountry("HK"))
+	assert.True(t, ValidCountry("US"))
+	assert.True(t, ValidCountry("KR"))
+	assert.False(t, ValidCountry("tw"))
+	assert.False(t, ValidCountry("XX"))
+	assert.False(t, ValidCountry(""))
 	assert.True(t, ValidPlatform("android"))
 	assert.True(t, ValidPlatform("ios"))
 	assert.False(t, ValidPlatform("windows"))
@@ -20,5 +24,36 @@ func TestValidEnum(t *testing.T) {
 	assert.True(t, ValidGender("F"))
 	assert.False(t, ValidGender("X"))
 	assert.False(t, ValidGender("Y"))
+	assert.True(t, ValidStatus("active"))
+	assert.True(t, ValidStatus("paused"))
+	assert.True(t, ValidStatus("archived"))
+	assert.False(t, ValidStatus("deleted"))
+}
+
+func TestRegisterEnum(t *testing.T) {
+	//the catalogs are global, so they are restored for the other tests
+	restoreCatalog(t, countries)
+	restoreCatalog(t, platforms)
+	restoreCatalog(t, genders)
+	assert.False(t, ValidCountry("XK"))
+	RegisterCountry("XK")
+	assert.True(t, ValidCountry("XK"))
+	assert.False(t, ValidPlatform("tv"))
+	RegisterPlatform("tv")
+	assert.True(t, ValidPlatform("tv"))
+	assert.False(t, ValidGender("X"))
+	RegisterGender("X")
+	assert.True(t, ValidGender("X"))
+}
 
+// restoreCatalog puts back the current values of the catalog when the test finishes
+func restoreCatalog[T comparable](t *testing.T, c *catalog[T]) {
+	c.mu.RLock()
+	values := maps.Clone(c.values)
+	c.mu.RUnlock()
+	t.Cleanup(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.values = values
+	})
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type EventKind string
+
+const (
+	Impression EventKind = "impression"
+	Click      EventKind = "click"
+)
+
+func ValidEventKind(kind EventKind) bool {
+	switch kind {
+	case Impression, Click:
+		return true
+	}
+	return false
+}
+
+// Event is an impression or a click of an ad, with the targeting params of the viewer
+type Event struct {
+	ID         uuid.UUID       `json:"id"`
+	AdID       uuid.UUID       `json:"adId"`
+	Kind       EventKind       `json:"kind"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Params     ConditionParams `json:"params"`
+}
+
+// EventReportRow counts the events of an ad on a day for one combination of gender, country and platform
+type EventReportRow struct {
+	Day         time.Time `json:"day"`
+	Gender      Gender    `json:"gender"`
+	Country     Country   `json:"country"`
+	Platform    Platform  `json:"platform"`
+	Impressions int64     `json:"impressions"`
+	Clicks      int64     `json:"clicks"`
+}
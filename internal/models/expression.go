@@ -0,0 +1,318 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"strconv"
+)
+
+// Field is a ConditionParams field an expression compares
+type Field string
+
+const (
+	FieldAge      Field = "age"
+	FieldGender   Field = "gender"
+	FieldCountry  Field = "country"
+	FieldPlatform Field = "platform"
+	// FieldSegment compares the segments of the viewer, in matches a viewer in any of the listed segment ids
+	FieldSegment Field = "segment"
+)
+
+// Operator compares a field with the values of an expression
+type Operator string
+
+const (
+	// OpIn matches when the field is one of the values
+	OpIn Operator = "in"
+	// OpNotIn matches when the field is none of the values
+	OpNotIn Operator = "not_in"
+	// OpRange matches when the age is between min and max, both inclusive and optional
+	OpRange Operator = "range"
+	// OpEq matches when the value equals the single value of the expression
+	OpEq Operator = "eq"
+	// OpGt, OpGte, OpLt and OpLte order a semver attribute against the single value of the expression
+	OpGt  Operator = "gt"
+	OpGte Operator = "gte"
+	OpLt  Operator = "lt"
+	OpLte Operator = "lte"
+)
+
+// limits of an expression, so evaluating the targeting of an ad stays cheap
+const (
+	MaxExpressionDepth = 8
+	MaxExpressionNodes = 100
+)
+
+// Expression is a boolean targeting rule over the ConditionParams fields and custom attributes.
+// A node either combines child expressions with exactly one of And, Or and Not,
+// or compares Field or a registered Attribute using Op with Values, or Min and Max for ranges.
+// A viewer without the attribute only matches not_in.
+// For example "everyone in TW except iOS" is
+//
+//	{"and": [{"field": "country", "op": "in", "values": ["TW"]}, {"field": "platform", "op": "not_in", "values": ["ios"]}]}
+type Expression struct {
+	And []Expression `json:"and,omitempty"`
+	Or  []Expression `json:"or,omitempty"`
+	Not *Expression  `json:"not,omitempty"`
+
+	Field     Field    `json:"field,omitempty"`
+	Attribute string   `json:"attribute,omitempty"`
+	Op        Operator `json:"op,omitempty"`
+	Values    []string `json:"values,omitempty"`
+	Min       *int     `json:"min,omitempty"`
+	Max       *int     `json:"max,omitempty"`
+}
+
+// IsZero reports if the expression is empty, such as when it's decoded from {}
+func (e Expression) IsZero() bool {
+	return e.And == nil && e.Or == nil && e.Not == nil && e.Field == "" && e.Attribute == "" && e.Op == "" &&
+		e.Values == nil && e.Min == nil && e.Max == nil
+}
+
+// Eval reports if the params match the expression
+func (e Expression) Eval(params ConditionParams) bool {
+	switch {
+	case e.And != nil:
+		for _, child := range e.And {
+			if !child.Eval(params) {
+				return false
+			}
+		}
+		return true
+	case e.Or != nil:
+		for _, child := range e.Or {
+			if child.Eval(params) {
+				return true
+			}
+		}
+		return false
+	case e.Not != nil:
+		return !e.Not.Eval(params)
+	}
+
+	if e.Op == OpRange {
+		return (e.Min == nil || params.Age >= *e.Min) && (e.Max == nil || params.Age <= *e.Max)
+	}
+	if e.Field == FieldSegment {
+		return e.evalSegments(params.Segments)
+	}
+	value, ok := fieldValue(e.Field, params), true
+	if e.Attribute != "" {
+		value, ok = params.Attributes[e.Attribute]
+	}
+	if !ok {
+		return e.Op == OpNotIn
+	}
+	equal := func(target string) bool {
+		return value == target
+	}
+	if e.Attribute != "" {
+		if attributeType, _ := LookupAttribute(e.Attribute); attributeType == AttributeSemver {
+			//versions are compared by precedence like the ordering operators, so 5.2 equals 5.2.0
+			equal = func(target string) bool {
+				return sameVersion(value, target)
+			}
+		}
+	}
+	switch e.Op {
+	case OpIn:
+		return slices.ContainsFunc(e.Values, equal)
+	case OpNotIn:
+		return !slices.ContainsFunc(e.Values, equal)
+	case OpEq:
+		return len(e.Values) == 1 && equal(e.Values[0])
+	case OpGt, OpGte, OpLt, OpLte:
+		return len(e.Values) == 1 && compareVersions(e.Op, value, e.Values[0])
+	}
+	return false
+}
+
+// evalSegments compares the segments of the viewer, which unlike the other fields can hold many values
+func (e Expression) evalSegments(viewerSegments []uuid.UUID) bool {
+	member := slices.ContainsFunc(viewerSegments, func(id uuid.UUID) bool {
+		return slices.Contains(e.Values, id.String())
+	})
+	switch e.Op {
+	case OpIn, OpEq:
+		return member
+	case OpNotIn:
+		return !member
+	}
+	return false
+}
+
+// sameVersion reports if both versions have the same precedence, invalid versions never match
+func sameVersion(value string, target string) bool {
+	a, ok := parseSemver(value)
+	if !ok {
+		return false
+	}
+	b, ok := parseSemver(target)
+	return ok && compareSemver(a, b) == 0
+}
+
+// compareVersions reports if value is ordered against target as the operator requires, invalid versions never match
+func compareVersions(op Operator, value string, target string) bool {
+	a, ok := parseSemver(value)
+	if !ok {
+		return false
+	}
+	b, ok := parseSemver(target)
+	if !ok {
+		return false
+	}
+	c := compareSemver(a, b)
+	switch op {
+	case OpGt:
+		return c > 0
+	case OpGte:
+		return c >= 0
+	case OpLt:
+		return c < 0
+	case OpLte:
+		return c <= 0
+	}
+	return false
+}
+
+func fieldValue(field Field, params ConditionParams) string {
+	switch field {
+	case FieldAge:
+		return strconv.Itoa(params.Age)
+	case FieldGender:
+		return string(params.Gender)
+	case FieldCountry:
+		return string(params.Country)
+	case FieldPlatform:
+		return string(params.Platform)
+	}
+	return ""
+}
+
+// Validate checks the shape of every node, the values of every field and the size of the expression
+func (e Expression) Validate() error {
+	nodes := 0
+	return e.validate(1, &nodes)
+}
+
+func (e Expression) validate(depth int, nodes *int) error {
+	*nodes++
+	if depth > MaxExpressionDepth {
+		return fmt.Errorf("expression is nested deeper than %d", MaxExpressionDepth)
+	}
+	if *nodes > MaxExpressionNodes {
+		return fmt.Errorf("expression has more than %d nodes", MaxExpressionNodes)
+	}
+
+	var children []Expression
+	kinds := 0
+	if e.And != nil {
+		kinds++
+		children = e.And
+	}
+	if e.Or != nil {
+		kinds++
+		children = e.Or
+	}
+	if e.Not != nil {
+		kinds++
+		children = []Expression{*e.Not}
+	}
+	if e.Op != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return errors.New("expression node needs exactly one of and, or, not and op")
+	}
+	if e.Op != "" {
+		return e.validateComparison()
+	}
+	if len(children) == 0 {
+		return errors.New("and and or need at least one expression")
+	}
+	for _, child := range children {
+		if err := child.validate(depth+1, nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e Expression) validateComparison() error {
+	if (e.Field == "") == (e.Attribute == "") {
+		return errors.New("comparison needs exactly one of field and attribute")
+	}
+	valid := func(value string) bool {
+		return validFieldValue(e.Field, value)
+	}
+	name := string(e.Field)
+	var attributeType AttributeType
+	if e.Attribute != "" {
+		var ok bool
+		attributeType, ok = LookupAttribute(e.Attribute)
+		if !ok {
+			return fmt.Errorf("unknown attribute %q", e.Attribute)
+		}
+		valid = func(value string) bool {
+			return ValidAttributeValue(attributeType, value)
+		}
+		name = e.Attribute
+	}
+
+	switch e.Op {
+	case OpRange:
+		if e.Field != FieldAge {
+			return errors.New("range is only supported for age")
+		}
+		if e.Min == nil && e.Max == nil {
+			return errors.New("range needs a min or a max")
+		}
+		if e.Min != nil && e.Max != nil && *e.Min > *e.Max {
+			return errors.New("range min must not be greater than max")
+		}
+		return nil
+	case OpIn, OpNotIn:
+		if len(e.Values) == 0 {
+			return fmt.Errorf("%s needs at least one value", e.Op)
+		}
+		for _, value := range e.Values {
+			if !valid(value) {
+				return fmt.Errorf("invalid %s %q", name, value)
+			}
+		}
+		return nil
+	case OpEq, OpGt, OpGte, OpLt, OpLte:
+		if len(e.Values) != 1 {
+			return fmt.Errorf("%s needs exactly one value", e.Op)
+		}
+		if e.Op != OpEq && attributeType != AttributeSemver {
+			return fmt.Errorf("%s is only supported for semver attributes", e.Op)
+		}
+		if !valid(e.Values[0]) {
+			return fmt.Errorf("invalid %s %q", name, e.Values[0])
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operator %q", e.Op)
+}
+
+func validFieldValue(field Field, value string) bool {
+	switch field {
+	case FieldAge:
+		age, err := strconv.Atoi(value)
+		return err == nil && age >= 0
+	case FieldGender:
+		return ValidGender(Gender(value))
+	case FieldCountry:
+		return ValidCountry(Country(value))
+	case FieldPlatform:
+		return ValidPlatform(Platform(value))
+	case FieldSegment:
+		//segment ids are compared as strings, so only the canonical form is accepted
+		id, err := uuid.Parse(value)
+		return err == nil && id.String() == value
+	}
+	return false
+}
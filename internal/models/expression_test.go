@@ -0,0 +1,246 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestExpressionEval(t *testing.T) {
+	//age 18-24 OR (female AND JP)
+	var expression Expression
+	err := json.Unmarshal([]byte(`{"or": [
+		{"field": "age", "op": "range", "min": 18, "max": 24},
+		{"and": [{"field": "gender", "op": "in", "values": ["F"]}, {"field": "country", "op": "in", "values": ["JP"]}]}
+	]}`), &expression)
+	require.NoError(t, err)
+	require.NoError(t, expression.Validate())
+	assert.True(t, expression.Eval(ConditionParams{Age: 18, Gender: Male, Country: Taiwan}))
+	assert.True(t, expression.Eval(ConditionParams{Age: 24, Gender: Male, Country: Taiwan}))
+	assert.False(t, expression.Eval(ConditionParams{Age: 25, Gender: Male, Country: Japan}))
+	assert.True(t, expression.Eval(ConditionParams{Age: 40, Gender: Female, Country: Japan}))
+
+	//everyone in TW except iOS
+	expression = Expression{}
+	err = json.Unmarshal([]byte(`{"and": [
+		{"field": "country", "op": "in", "values": ["TW"]},
+		{"not": {"field": "platform", "op": "in", "values": ["ios"]}}
+	]}`), &expression)
+	require.NoError(t, err)
+	require.NoError(t, expression.Validate())
+	assert.True(t, expression.Eval(ConditionParams{Country: Taiwan, Platform: Web}))
+	assert.False(t, expression.Eval(ConditionParams{Country: Taiwan, Platform: Ios}))
+	assert.False(t, expression.Eval(ConditionParams{Country: Japan, Platform: Web}))
+
+	notIn := Expression{Field: FieldPlatform, Op: OpNotIn, Values: []string{"ios"}}
+	assert.True(t, notIn.Eval(ConditionParams{Platform: Android}))
+	assert.False(t, notIn.Eval(ConditionParams{Platform: Ios}))
+}
+
+func TestExpressionValidate(t *testing.T) {
+	tw := Expression{Field: FieldCountry, Op: OpIn, Values: []string{"TW"}}
+	deep := tw
+	for range MaxExpressionDepth {
+		deep = Expression{Not: &deep}
+	}
+	wide := Expression{Or: make([]Expression, MaxExpressionNodes)}
+	for i := range wide.Or {
+		wide.Or[i] = tw
+	}
+	age := 20
+
+	for _, invalid := range []Expression{
+		{},
+		{And: []Expression{}},
+		{And: []Expression{tw}, Or: []Expression{tw}},
+		{And: []Expression{tw}, Field: FieldCountry, Op: OpIn, Values: []string{"TW"}},
+		{Field: FieldCountry, Op: OpIn},
+		{Field: FieldCountry, Op: OpIn, Values: []string{"XX"}},
+		{Field: FieldPlatform, Op: OpNotIn, Values: []string{"fridge"}},
+		{Field: "income", Op: OpIn, Values: []string{"high"}},
+		{Field: FieldCountry, Op: "like", Values: []string{"TW"}},
+		{Field: FieldCountry, Op: OpRange, Min: &age},
+		{Field: FieldAge, Op: OpRange},
+		{Field: FieldAge, Op: OpRange, Min: &age, Max: new(int)},
+		deep,
+		wide,
+	} {
+		assert.Error(t, invalid.Validate(), invalid)
+	}
+	assert.NoError(t, Expression{Field: FieldAge, Op: OpRange, Min: &age}.Validate())
+	assert.NoError(t, Expression{Field: FieldAge, Op: OpIn, Values: []string{"20"}}.Validate())
+}
+
+func TestExpressionAttributes(t *testing.T) {
+	require.NoError(t, RegisterAttribute("board", AttributeString))
+	require.NoError(t, RegisterAttribute("app_version", AttributeSemver))
+
+	//app 5.2 or newer, on any board except nsfw
+	var expression Expression
+	err := json.Unmarshal([]byte(`{"and": [
+		{"attribute": "app_version", "op": "gte", "values": ["5.2"]},
+		{"attribute": "board", "op": "not_in", "values": ["nsfw"]}
+	]}`), &expression)
+	require.NoError(t, err)
+	require.NoError(t, expression.Validate())
+	params := func(attributes map[string]string) ConditionParams {
+		return ConditionParams{Age: 30, Country: Taiwan, Attributes: attributes}
+	}
+	assert.True(t, expression.Eval(params(map[string]string{"app_version": "5.2.0", "board": "pets"})))
+	assert.True(t, expression.Eval(params(map[string]string{"app_version": "5.10.1"})))
+	assert.False(t, expression.Eval(params(map[string]string{"app_version": "5.2.0-beta.1"})))
+	assert.False(t, expression.Eval(params(map[string]string{"app_version": "5.10.1", "board": "nsfw"})))
+	//a missing attribute only matches not_in
+	assert.False(t, expression.Eval(params(nil)))
+
+	eq := Expression{Attribute: "board", Op: OpEq, Values: []string{"pets"}}
+	assert.True(t, eq.Eval(params(map[string]string{"board": "pets"})))
+	assert.False(t, eq.Eval(params(map[string]string{"board": "food"})))
+	in := Expression{Attribute: "board", Op: OpIn, Values: []string{"pets", "food"}}
+	assert.True(t, in.Eval(params(map[string]string{"board": "food"})))
+	assert.False(t, in.Eval(params(nil)))
+	below := Expression{Attribute: "app_version", Op: OpLt, Values: []string{"5"}}
+	assert.True(t, below.Eval(params(map[string]string{"app_version": "4.9.9"})))
+	assert.False(t, below.Eval(params(map[string]string{"app_version": "5.0.0"})))
+	//versions are equal by precedence, not by their text
+	version := Expression{Attribute: "app_version", Op: OpEq, Values: []string{"1.2"}}
+	assert.True(t, version.Eval(params(map[string]string{"app_version": "1.2.0"})))
+	assert.True(t, version.Eval(params(map[string]string{"app_version": "v1.2.0+build"})))
+	assert.False(t, version.Eval(params(map[string]string{"app_version": "1.2.0-rc.1"})))
+	versions := Expression{Attribute: "app_version", Op: OpNotIn, Values: []string{"1.2", "2"}}
+	assert.False(t, versions.Eval(params(map[string]string{"app_version": "2.0.0"})))
+	assert.True(t, versions.Eval(params(map[string]string{"app_version": "2.0.1"})))
+
+	for _, invalid := range []Expression{
+		{Attribute: "unregistered", Op: OpEq, Values: []string{"a"}},
+		{Attribute: "board", Field: FieldCountry, Op: OpIn, Values: []string{"TW"}},
+		{Attribute: "board", Op: OpGt, Values: []string{"1.0.0"}},
+		{Attribute: "board", Op: OpEq, Values: []string{"a", "b"}},
+		{Attribute: "board", Op: OpRange, Min: new(int)},
+		{Attribute: "app_version", Op: OpGte, Values: []string{"latest"}},
+		{Attribute: "app_version", Op: OpLt},
+		{Op: OpIn, Values: []string{"TW"}},
+	} {
+		assert.Error(t, invalid.Validate(), invalid)
+	}
+	assert.NoError(t, Expression{Field: FieldCountry, Op: OpEq, Values: []string{"TW"}}.Validate())
+}
+
+func TestExpressionSegments(t *testing.T) {
+	visitors, buyers := uuid.New(), uuid.New()
+	//makeup board visitors who haven't bought yet
+	expression := Expression{And: []Expression{
+		{Field: FieldSegment, Op: OpIn, Values: []string{visitors.String()}},
+		{Field: FieldSegment, Op: OpNotIn, Values: []string{buyers.String()}},
+	}}
+	require.NoError(t, expression.Validate())
+	assert.True(t, expression.Eval(ConditionParams{Segments: []uuid.UUID{visitors}}))
+	assert.False(t, expression.Eval(ConditionParams{Segments: []uuid.UUID{visitors, buyers}}))
+	assert.False(t, expression.Eval(ConditionParams{}))
+	assert.Equal(t, 2, len(Ad{Targeting: &expression}.Segments()))
+
+	assert.Error(t, Expression{Field: FieldSegment, Op: OpIn, Values: []string{"visitors"}}.Validate())
+	assert.Error(t, Expression{Field: FieldSegment, Op: OpIn, Values: []string{strings.ToUpper(visitors.String())}}.Validate())
+}
+
+// the conditions are sugar for an expression, so both have to match the same params
+func TestConditionsExpression(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	segments := []uuid.UUID{uuid.New(), uuid.New()}
+	pick := func(values ...string) []string {
+		var picked []string
+		for _, value := range values {
+			if r.Intn(2) == 0 {
+				picked = append(picked, value)
+			}
+		}
+		return picked
+	}
+	for range 200 {
+		var conditions []Condition
+		for range r.Intn(3) {
+			condition := Condition{
+				Gender:   toEnum[Gender](pick("M", "F")),
+				Country:  toEnum[Country](pick("TW", "JP")),
+				Platform: toEnum[Platform](pick("android", "ios", "web")),
+			}
+			if r.Intn(2) == 0 {
+				condition.AgeStart = r.Intn(60)
+				condition.AgeEnd = condition.AgeStart + r.Intn(40)
+			}
+			if r.Intn(3) == 0 {
+				condition.Segments = segments[:1+r.Intn(2)]
+			}
+			conditions = append(conditions, condition)
+		}
+		ad := Ad{Conditions: conditions}
+		expression := conditionsExpression(conditions)
+		for range 20 {
+			params := ConditionParams{
+				Age:      r.Intn(100),
+				Gender:   []Gender{Male, Female}[r.Intn(2)],
+				Country:  []Country{Taiwan, Japan, HongKong}[r.Intn(3)],
+				Platform: []Platform{Android, Ios, Web}[r.Intn(3)],
+				Segments: segments[r.Intn(2) : r.Intn(2)+1],
+			}
+			require.Equal(t, ad.MatchTargeting(params), expression.Eval(params), "%v %v", conditions, params)
+		}
+	}
+}
+
+func toEnum[T ~string](values []string) []T {
+	result := make([]T, len(values))
+	for i, value := range values {
+		result[i] = T(value)
+	}
+	return result
+}
+
+// conditionsExpression returns the expression equivalent to a list of conditions, which are OR'ed together,
+// it documents that MatchTargeting gives conditions the same meaning as an expression.
+// Each condition is the AND of the dimensions it restricts, an ad without conditions matches everyone
+// through an empty And, which is only meant to be evaluated since it doesn't pass Validate.
+func conditionsExpression(conditions []Condition) Expression {
+	if len(conditions) == 0 {
+		return Expression{And: []Expression{}}
+	}
+	or := make([]Expression, len(conditions))
+	for i, condition := range conditions {
+		and := []Expression{}
+		//the age range of a condition excludes both ends
+		if condition.AgeStart != 0 || condition.AgeEnd != 0 {
+			minAge, maxAge := condition.AgeStart+1, condition.AgeEnd-1
+			and = append(and, Expression{Field: FieldAge, Op: OpRange, Min: &minAge, Max: &maxAge})
+		}
+		if len(condition.Gender) > 0 {
+			and = append(and, Expression{Field: FieldGender, Op: OpIn, Values: toStrings(condition.Gender)})
+		}
+		if len(condition.Country) > 0 {
+			and = append(and, Expression{Field: FieldCountry, Op: OpIn, Values: toStrings(condition.Country)})
+		}
+		if len(condition.Platform) > 0 {
+			and = append(and, Expression{Field: FieldPlatform, Op: OpIn, Values: toStrings(condition.Platform)})
+		}
+		if len(condition.Segments) > 0 {
+			segments := make([]string, len(condition.Segments))
+			for j, id := range condition.Segments {
+				segments[j] = id.String()
+			}
+			and = append(and, Expression{Field: FieldSegment, Op: OpIn, Values: segments})
+		}
+		or[i] = Expression{And: and}
+	}
+	return Expression{Or: or}
+}
+
+func toStrings[T ~string](values []T) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = string(value)
+	}
+	return result
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// FrequencyCap limits how many times a single viewer is shown an ad within a window,
+// the window starts at the first impression of the viewer.
+type FrequencyCap struct {
+	Max           int   `json:"max"`
+	WindowSeconds int64 `json:"windowSeconds"`
+}
+
+func (f FrequencyCap) Window() time.Duration {
+	return time.Duration(f.WindowSeconds) * time.Second
+}
@@ -7,11 +7,13 @@ const (
 	Female Gender = "F"
 )
 
+var genders = newCatalog(Male, Female)
+
 func ValidGender(gender Gender) bool {
-	switch gender {
-	case Male, Female:
-		return true
-	default:
-		return false
-	}
+	return genders.contains(gender)
+}
+
+// RegisterGender adds a gender that can be targeted
+func RegisterGender(gender Gender) {
+	genders.add(gender)
 }
@@ -8,10 +8,13 @@ const (
 	Web     Platform = "web"
 )
 
+var platforms = newCatalog(Android, Ios, Web)
+
 func ValidPlatform(platform Platform) bool {
-	switch platform {
-	case Android, Ios, Web:
-		return true
-	}
-	return false
+	return platforms.contains(platform)
+}
+
+// RegisterPlatform adds a platform that can be targeted, e.g. a new app
+func RegisterPlatform(platform Platform) {
+	platforms.add(platform)
 }
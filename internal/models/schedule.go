@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Schedule restricts an ad to weekly time windows, evaluated in the timezone of the schedule
+type Schedule struct {
+	// Timezone is an IANA name such as Asia/Taipei, empty means UTC
+	Timezone string           `json:"timezone,omitempty"`
+	Windows  []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is a time of day range on some days of the week.
+// A window whose end isn't after its start crosses midnight, and ends on the next day.
+type ScheduleWindow struct {
+	// Days the window starts on, 0 is Sunday
+	Days []time.Weekday `json:"days"`
+	// Start is inclusive and End exclusive, both formatted as HH:MM, End may be 24:00
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// errLocalTimezone rejects the timezone of the server, which replicas may not share
+var errLocalTimezone = errors.New(`timezone "Local" depends on the server, use an IANA name such as Asia/Taipei`)
+
+// locations caches the loaded timezones, since loading one reads and parses the tz database
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, errLocalTimezone
+	}
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// minuteOfDay parses HH:MM into minutes since midnight, 24:00 is the end of the day
+func minuteOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err == nil {
+		return parsed.Hour()*60 + parsed.Minute(), nil
+	}
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+}
+
+// Validate checks that the timezone is an IANA name or empty, and every window is well formed
+func (s Schedule) Validate() error {
+	_, err := loadLocation(s.Timezone)
+	if errors.Is(err, errLocalTimezone) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	if len(s.Windows) == 0 {
+		return errors.New("schedule needs at least one window")
+	}
+	for _, window := range s.Windows {
+		if len(window.Days) == 0 {
+			return errors.New("schedule window needs at least one day")
+		}
+		for _, day := range window.Days {
+			if day < time.Sunday || day > time.Saturday {
+				return fmt.Errorf("invalid day %d, expected 0 (Sunday) to 6 (Saturday)", day)
+			}
+		}
+		start, err := minuteOfDay(window.Start)
+		if err != nil || start == 24*60 {
+			return fmt.Errorf("invalid window start %q, expected HH:MM", window.Start)
+		}
+		end, err := minuteOfDay(window.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New("schedule window can't start and end at the same time")
+		}
+	}
+	return nil
+}
+
+// ActiveAt reports if now falls within one of the windows.
+// An invalid schedule is never active, so a broken schedule can't run an ad around the clock.
+func (s Schedule) ActiveAt(now time.Time) bool {
+	location, err := loadLocation(s.Timezone)
+	if err != nil {
+		return false
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+	for _, window := range s.Windows {
+		start, err := minuteOfDay(window.Start)
+		if err != nil {
+			continue
+		}
+		end, err := minuteOfDay(window.End)
+		if err != nil {
+			continue
+		}
+		if start < end {
+			if slices.Contains(window.Days, today) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		//the window crosses midnight, so it's either in the part started today or the part started yesterday
+		if (slices.Contains(window.Days, today) && minute >= start) || (slices.Contains(window.Days, yesterday) && minute < end) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+	taipei, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := Schedule{
+		Timezone: "Asia/Taipei",
+		Windows: []ScheduleWindow{
+			//weekday lunchtime
+			{Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, Start: "11:30", End: "13:30"},
+			//friday and saturday nights, until 2am of the next day
+			{Days: []time.Weekday{time.Friday, time.Saturday}, Start: "22:00", End: "02:00"},
+		},
+	}
+	assert.NoError(t, schedule.Validate())
+
+	//2024-01-01 is a Monday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, taipei).UTC()
+	}
+	assert.True(t, schedule.ActiveAt(at(1, 11, 30)))
+	assert.True(t, schedule.ActiveAt(at(1, 13, 29)))
+	assert.False(t, schedule.ActiveAt(at(1, 13, 30)))
+	assert.False(t, schedule.ActiveAt(at(1, 11, 29)))
+	//lunchtime in Taipei is 03:30 UTC
+	assert.True(t, schedule.ActiveAt(time.Date(2024, 1, 1, 3, 30, 0, 0, time.UTC)))
+	assert.False(t, schedule.ActiveAt(at(6, 12, 0)))
+
+	assert.True(t, schedule.ActiveAt(at(5, 23, 0)))
+	assert.True(t, schedule.ActiveAt(at(6, 1, 59)))
+	assert.True(t, schedule.ActiveAt(at(7, 1, 0)))
+	assert.False(t, schedule.ActiveAt(at(8, 1, 0)))
+	assert.False(t, schedule.ActiveAt(at(4, 23, 0)))
+
+	allDay := Schedule{Windows: []ScheduleWindow{{Days: []time.Weekday{time.Sunday}, Start: "00:00", End: "24:00"}}}
+	assert.NoError(t, allDay.Validate())
+	assert.True(t, allDay.ActiveAt(time.Date(2024, 1, 7, 23, 59, 0, 0, time.UTC)))
+	assert.False(t, allDay.ActiveAt(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestScheduleValidate(t *testing.T) {
+	weekend := []time.Weekday{time.Saturday, time.Sunday}
+	for _, invalid := range []Schedule{
+		{Timezone: "Mars/Olympus", Windows: []ScheduleWindow{{Days: weekend, Start: "10:00", End: "12:00"}}},
+		{Timezone: "Local", Windows: []ScheduleWindow{{Days: weekend, Start: "10:00", End: "12:00"}}},
+		{},
+		{Windows: []ScheduleWindow{{Start: "10:00", End: "12:00"}}},
+		{Windows: []ScheduleWindow{{Days: []time.Weekday{7}, Start: "10:00", End: "12:00"}}},
+		{Windows: []ScheduleWindow{{Days: weekend, Start: "10am", End: "12:00"}}},
+		{Windows: []ScheduleWindow{{Days: weekend, Start: "24:00", End: "12:00"}}},
+		{Windows: []ScheduleWindow{{Days: weekend, Start: "10:00", End: "10:00"}}},
+	} {
+		assert.Error(t, invalid.Validate(), invalid)
+	}
+}
+
+func TestIsActiveAtWithSchedule(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ad := Ad{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	assert.True(t, ad.IsActiveAt(now))
+	ad.Schedule = &Schedule{Windows: []ScheduleWindow{{Days: []time.Weekday{time.Saturday}, Start: "00:00", End: "24:00"}}}
+	assert.False(t, ad.IsActiveAt(now))
+	ad.Schedule.Windows[0].Days = []time.Weekday{time.Monday}
+	assert.True(t, ad.IsActiveAt(now))
+	assert.False(t, ad.IsActiveAt(now.Add(2*time.Hour)))
+}
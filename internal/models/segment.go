@@ -0,0 +1,62 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// Segment is an uploaded list of viewers that ads can be retargeted to, only its metadata is stored in the database
+// while the members are kept in the cache
+type Segment struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Segments returns the segments referenced by the targeting of the ad, without duplicates
+func (ad Ad) Segments() []uuid.UUID {
+	var segments []uuid.UUID
+	if ad.Targeting != nil {
+		segments = ad.Targeting.segments(segments)
+	} else {
+		for _, condition := range ad.Conditions {
+			segments = append(segments, condition.Segments...)
+		}
+	}
+	slices.SortFunc(segments, compareUUID)
+	return slices.Compact(segments)
+}
+
+// segments appends the segments compared by the expression and its children
+func (e Expression) segments(segments []uuid.UUID) []uuid.UUID {
+	for _, child := range e.And {
+		segments = child.segments(segments)
+	}
+	for _, child := range e.Or {
+		segments = child.segments(segments)
+	}
+	if e.Not != nil {
+		segments = e.Not.segments(segments)
+	}
+	if e.Field == FieldSegment {
+		for _, value := range e.Values {
+			if id, err := uuid.Parse(value); err == nil {
+				segments = append(segments, id)
+			}
+		}
+	}
+	return segments
+}
+
+// inSegments reports if the viewer belongs to any of the segments
+func inSegments(viewerSegments []uuid.UUID, segments []uuid.UUID) bool {
+	return slices.ContainsFunc(segments, func(id uuid.UUID) bool {
+		return slices.Contains(viewerSegments, id)
+	})
+}
+
+func compareUUID(a, b uuid.UUID) int {
+	return slices.Compare(a[:], b[:])
+}
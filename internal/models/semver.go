@@ -0,0 +1,86 @@
+package models
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, missing minor and patch numbers are 0 so app versions such as 5.2 are accepted
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+// parseSemver parses MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD] with an optional v prefix, the build metadata is ignored
+func parseSemver(value string) (semver, bool) {
+	value = strings.TrimPrefix(value, "v")
+	value, _, _ = strings.Cut(value, "+")
+	value, prerelease, hasPrerelease := strings.Cut(value, "-")
+
+	var version semver
+	parts := strings.Split(value, ".")
+	if len(parts) > len(version.numbers) {
+		return semver{}, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || (len(part) > 1 && part[0] == '0') {
+			return semver{}, false
+		}
+		version.numbers[i] = number
+	}
+	if hasPrerelease {
+		version.prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range version.prerelease {
+			if identifier == "" {
+				return semver{}, false
+			}
+		}
+	}
+	return version, true
+}
+
+// compareSemver orders two versions by precedence, a version with a prerelease comes before the release
+func compareSemver(a, b semver) int {
+	for i := range a.numbers {
+		if c := cmp.Compare(a.numbers[i], b.numbers[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := comparePrerelease(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a.prerelease), len(b.prerelease))
+}
+
+// comparePrerelease compares numeric identifiers numerically, and before alphanumeric ones
+func comparePrerelease(a, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// ValidSemver reports if the value is a version accepted by semver attributes
+func ValidSemver(value string) bool {
+	_, ok := parseSemver(value)
+	return ok
+}
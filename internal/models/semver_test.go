@@ -0,0 +1,39 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	//sorted by precedence
+	versions := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "v1.0.1", "1.2", "1.10.0", "2"}
+	for i := range versions {
+		for j := range versions {
+			a, ok := parseSemver(versions[i])
+			require.True(t, ok, versions[i])
+			b, ok := parseSemver(versions[j])
+			require.True(t, ok, versions[j])
+			assert.Equal(t, cmpInt(i, j), compareSemver(a, b), "%s %s", versions[i], versions[j])
+		}
+	}
+
+	a, _ := parseSemver("1.2.0+build.5")
+	b, _ := parseSemver("1.2")
+	assert.Equal(t, 0, compareSemver(a, b))
+
+	for _, invalid := range []string{"", "a.b", "1.2.3.4", "01.2", "1.-2", "1.0.0-", "1.0.0-a..b"} {
+		assert.False(t, ValidSemver(invalid), invalid)
+	}
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
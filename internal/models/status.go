@@ -0,0 +1,20 @@
+package models
+
+type Status string
+
+const (
+	// StatusActive ads are served within their start and end time
+	StatusActive Status = "active"
+	// StatusPaused ads are temporarily not served, and can be resumed later
+	StatusPaused Status = "paused"
+	// StatusArchived ads are taken down for good
+	StatusArchived Status = "archived"
+)
+
+func ValidStatus(status Status) bool {
+	switch status {
+	case StatusActive, StatusPaused, StatusArchived:
+		return true
+	}
+	return false
+}
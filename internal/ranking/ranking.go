@@ -0,0 +1,158 @@
+package ranking
+
+import (
+	"advertise_service/internal/models"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/uuid"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Names of the strategies, selected by the RANKING env var
+const (
+	EndTime  = "end_time"
+	Priority = "priority"
+	Weighted = "weighted"
+	ECPM     = "ecpm"
+)
+
+// Strategy orders the matched ads, the first ads get the top slots.
+// The ads are given sorted by end time, as they come out of the targeting index.
+// Rank must return the same order for the same ads and seed, so later pages can reproduce the order of the first one.
+type Strategy interface {
+	Name() string
+	Rank(ads []models.Ad, seed uint64) []models.Ad
+}
+
+// Ranker is the strategy used by get ads with the source of seeds for the first page of a request
+type Ranker struct {
+	Strategy Strategy
+	// NewSeed seeds the first page, later pages reuse the seed from the cursor
+	NewSeed func() uint64
+}
+
+// NewRanker returns the ranker of the named strategy, the empty name is EndTime.
+// Seeds are random, tests can replace NewSeed to get a deterministic order.
+func NewRanker(name string) (Ranker, error) {
+	var strategy Strategy
+	switch name {
+	case "", EndTime:
+		strategy = endTime{}
+	case Priority:
+		strategy = priority{}
+	case Weighted:
+		strategy = weighted{}
+	case ECPM:
+		strategy = ecpm{}
+	default:
+		return Ranker{}, fmt.Errorf("unknown ranking strategy %q", name)
+	}
+	return Ranker{Strategy: strategy, NewSeed: rand.Uint64}, nil
+}
+
+// ByEndTime reports if the ads keep the order of the cache, so pages can resume at a position in the cache
+// instead of an offset in the ranked ads.
+func (r Ranker) ByEndTime() bool {
+	return r.Strategy.Name() == EndTime
+}
+
+// endTime keeps the ads ending first at the top
+type endTime struct{}
+
+func (endTime) Name() string { return EndTime }
+
+func (endTime) Rank(ads []models.Ad, _ uint64) []models.Ad {
+	return ads
+}
+
+// priority puts higher priority ads first, ads with the same priority are ordered by end time
+type priority struct{}
+
+func (priority) Name() string { return Priority }
+
+func (priority) Rank(ads []models.Ad, _ uint64) []models.Ad {
+	ranked := slices.Clone(ads)
+	slices.SortStableFunc(ranked, comparePriority)
+	return ranked
+}
+
+// weighted rotates ads with the same priority, an ad takes the top slot with a probability proportional to its weight
+type weighted struct{}
+
+func (weighted) Name() string { return Weighted }
+
+func (weighted) Rank(ads []models.Ad, seed uint64) []models.Ad {
+	//weighted sampling without replacement by Efraimidis-Spirakis, every ad draws the key u^(1/weight)
+	//and the ads are sorted by the key. u only depends on the seed and the ad, so the same seed gives the same order
+	//and ads that expire or get removed between pages don't reshuffle the others
+	keys := make([]float64, len(ads))
+	ranked := make([]int, len(ads))
+	for i, ad := range ads {
+		ranked[i] = i
+		keys[i] = math.Pow(uniform(seed, ad.ID), 1/float64(weightOf(ad)))
+	}
+	slices.SortStableFunc(ranked, func(a, b int) int {
+		if c := comparePriority(ads[a], ads[b]); c != 0 {
+			return c
+		}
+		return cmp.Compare(keys[b], keys[a])
+	})
+
+	result := make([]models.Ad, len(ads))
+	for i, index := range ranked {
+		result[i] = ads[index]
+	}
+	return result
+}
+
+// uniform hashes the seed and the ad id into a number in [0, 1)
+func uniform(seed uint64, id uuid.UUID) float64 {
+	hash := mix(seed ^ mix(binary.BigEndian.Uint64(id[:8])^mix(binary.BigEndian.Uint64(id[8:]))))
+	return float64(hash>>11) / (1 << 53)
+}
+
+// mix is the finalizer of splitmix64, every bit of x affects every bit of the result
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ecpm puts the ads that earn the most per thousand impressions first, which is the impression cost of their budget.
+// Ads without a budget earn nothing, ties are ordered by priority and then end time.
+type ecpm struct{}
+
+func (ecpm) Name() string { return ECPM }
+
+func (ecpm) Rank(ads []models.Ad, _ uint64) []models.Ad {
+	ranked := slices.Clone(ads)
+	slices.SortStableFunc(ranked, func(a, b models.Ad) int {
+		if c := cmp.Compare(ECPMOf(b), ECPMOf(a)); c != 0 {
+			return c
+		}
+		return comparePriority(a, b)
+	})
+	return ranked
+}
+
+// ECPMOf returns the revenue of a thousand impressions of the ad
+func ECPMOf(ad models.Ad) int64 {
+	if ad.Budget == nil {
+		return 0
+	}
+	return ad.Budget.ImpressionCost * 1000
+}
+
+func comparePriority(a, b models.Ad) int {
+	return cmp.Compare(b.Priority, a.Priority)
+}
+
+func weightOf(ad models.Ad) int {
+	return max(ad.Weight, 1)
+}
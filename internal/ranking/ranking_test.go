@@ -0,0 +1,96 @@
+package ranking
+
+import (
+	"advertise_service/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+func titles(ads []models.Ad) []string {
+	result := make([]string, len(ads))
+	for i, ad := range ads {
+		result[i] = ad.Title
+	}
+	return result
+}
+
+func newAd(title string, priority int, weight int, impressionCost int64) models.Ad {
+	ad := models.Ad{ID: uuid.New(), Title: title, Priority: priority, Weight: weight}
+	if impressionCost > 0 {
+		ad.Budget = &models.Budget{Total: 1000, ImpressionCost: impressionCost}
+	}
+	return ad
+}
+
+func TestNewRanker(t *testing.T) {
+	for _, name := range []string{"", EndTime, Priority, Weighted, ECPM} {
+		ranker, err := NewRanker(name)
+		require.NoError(t, err)
+		assert.Equal(t, name == "" || name == EndTime, ranker.ByEndTime())
+	}
+	_, err := NewRanker("alphabetical")
+	assert.Error(t, err)
+}
+
+func TestRank(t *testing.T) {
+	//sorted by end time
+	ads := []models.Ad{
+		newAd("a", 0, 0, 0),
+		newAd("b", 2, 0, 1),
+		newAd("c", 1, 0, 5),
+		newAd("d", 2, 0, 1),
+	}
+
+	ranker, _ := NewRanker(EndTime)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, titles(ranker.Strategy.Rank(ads, 0)))
+	ranker, _ = NewRanker(Priority)
+	assert.Equal(t, []string{"b", "d", "c", "a"}, titles(ranker.Strategy.Rank(ads, 0)))
+	ranker, _ = NewRanker(ECPM)
+	assert.Equal(t, []string{"c", "b", "d", "a"}, titles(ranker.Strategy.Rank(ads, 0)))
+	//the given ads are left untouched
+	assert.Equal(t, []string{"a", "b", "c", "d"}, titles(ads))
+}
+
+func TestWeighted(t *testing.T) {
+	ranker, _ := NewRanker(Weighted)
+	ads := []models.Ad{
+		newAd("light", 0, 1, 0),
+		newAd("heavy", 0, 9, 0),
+		newAd("top", 1, 1, 0),
+	}
+
+	//the same seed gives the same order
+	assert.Equal(t, ranker.Strategy.Rank(ads, 42), ranker.Strategy.Rank(ads, 42))
+
+	first := map[string]int{}
+	for seed := range uint64(1000) {
+		ranked := ranker.Strategy.Rank(ads, seed)
+		//a higher priority always comes first
+		require.Equal(t, "top", ranked[0].Title)
+		first[ranked[1].Title]++
+	}
+	//the heavy ad should win about 90% of the rotations
+	assert.InDelta(t, 900, first["heavy"], 60)
+}
+
+func TestWeightedStableAcrossPages(t *testing.T) {
+	ranker, _ := NewRanker(Weighted)
+	var ads []models.Ad
+	for i := range 20 {
+		ads = append(ads, newAd(string(rune('a'+i)), 0, i%5+1, 0))
+	}
+	ranked := ranker.Strategy.Rank(ads, 7)
+
+	//an ad expiring between two pages leaves the order of the other ads untouched
+	removed := ranked[3]
+	remaining := slices.DeleteFunc(slices.Clone(ads), func(ad models.Ad) bool {
+		return ad.ID == removed.ID
+	})
+	expected := slices.DeleteFunc(slices.Clone(ranked), func(ad models.Ad) bool {
+		return ad.ID == removed.ID
+	})
+	assert.Equal(t, titles(expected), titles(ranker.Strategy.Rank(remaining, 7)))
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	// RefreshPeriod is how old the cache may get before it's refreshed,
+	// it's shorter than cache.Interval so the cache is refreshed before it becomes invalid.
+	RefreshPeriod = cache.Interval - cache.Tolerance
+
+	// CheckPeriod is how often each replica checks if the cache needs a refresh
+	CheckPeriod = time.Minute
+)
+
+// CacheRefresher loads the active ads from the storage into the cache in the background,
+// so requests never have to rebuild the cache themselves.
+type CacheRefresher struct {
+	storage persistent.Storage
+	cache   cache.Service
+	logger  *zap.Logger
+}
+
+func NewCacheRefresher(storage persistent.Storage, cache cache.Service, logger *zap.Logger) CacheRefresher {
+	return CacheRefresher{
+		storage: storage,
+		cache:   cache,
+		logger:  logger,
+	}
+}
+
+// Run refreshes the cache whenever it's older than RefreshPeriod, until ctx is done.
+func (r CacheRefresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckPeriod)
+	defer ticker.Stop()
+	for {
+		r.refreshIfStale(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r CacheRefresher) refreshIfStale(ctx context.Context) {
+	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, r.logger)
+	lastUpdate, err := r.cache.LastUpdate(ctx)
+	if err != nil {
+		r.logger.Log(zap.ErrorLevel, "error reading last cache update", zap.Error(err))
+		return
+	}
+	//another replica may have refreshed the cache already
+	if time.Since(lastUpdate) < RefreshPeriod {
+		return
+	}
+	_, err = r.Refresh(ctx)
+	if err != nil {
+		r.logger.Log(zap.ErrorLevel, "error refreshing cache", zap.Error(err))
+	}
+}
+
+// Refresh writes the ads that are active or will start within the next cache window into the cache.
+// Returns 0 without an error if another replica is refreshing the cache at the same time.
+func (r CacheRefresher) Refresh(ctx context.Context) (int, error) {
+	ctx = context.WithValue(ctx, logging.LoggerContextKey{}, r.logger)
+	//the version is read before the ads, so the ads cached while they are loaded aren't removed as inactive
+	version, err := r.cache.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	ads, err := r.storage.FindAdsWithTime(ctx, now.Add(cache.Interval+cache.Tolerance), now)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := r.cache.Update(ctx, ads, version)
+	if errors.Is(err, cache.ErrUpdateLocked) {
+		r.logger.Log(zap.DebugLevel, "cache is being refreshed by another replica")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	r.logger.Log(zap.DebugLevel, "refreshed cache", zap.Int("written", written))
+	return written, nil
+}
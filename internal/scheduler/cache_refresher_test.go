@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/infra/logging"
+	"advertise_service/internal/infra/persistent"
+	"advertise_service/internal/mock"
+	"advertise_service/internal/models"
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheRefresher(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
+	storage := mock.NewStorage()
+	cacheService := mock.NewCache()
+
+	active := 0
+	for _, ad := range mock.GenerateMockAds() {
+		require.NoError(t, storage.InsertAd(ctx, ad))
+		if !strings.Contains(ad.Title, "inactive") {
+			active++
+		}
+	}
+
+	refresher := NewCacheRefresher(storage, cacheService, logger)
+	written, err := refresher.Refresh(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, active, written)
+
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	assert.Len(t, cached, active)
+
+	lastUpdate, err := cacheService.LastUpdate(ctx)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), lastUpdate, time.Minute)
+
+	//a fresh cache is left untouched
+	refresher.refreshIfStale(ctx)
+	lastUpdateAfter, err := cacheService.LastUpdate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, lastUpdate, lastUpdateAfter)
+}
+
+// cachingStorage caches an ad while the ads of a refresh are loaded, like a request creating an ad at the same time
+type cachingStorage struct {
+	persistent.Storage
+	cache cache.Service
+	ad    models.Ad
+}
+
+func (s cachingStorage) FindAdsWithTime(ctx context.Context, startBefore time.Time, endAfter time.Time) ([]models.Ad, error) {
+	ads, err := s.Storage.FindAdsWithTime(ctx, startBefore, endAfter)
+	if err != nil {
+		return nil, err
+	}
+	return ads, s.cache.WriteActiveAd(ctx, s.ad)
+}
+
+func TestCacheRefresherKeepsAdsCachedDuringRefresh(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.WithValue(context.Background(), logging.LoggerContextKey{}, logger)
+	cacheService := mock.NewCache()
+	now := time.Now().UTC()
+	created := models.Ad{ID: uuid.New(), Title: "created", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	storage := cachingStorage{Storage: mock.NewStorage(), cache: cacheService, ad: created}
+
+	_, err := NewCacheRefresher(storage, cacheService, logger).Refresh(ctx)
+	require.NoError(t, err)
+	cached, err := cacheService.GetActiveAds(ctx, cache.Cursor{}, math.MaxInt)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, created.ID, cached[0].ID)
+}
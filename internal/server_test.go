@@ -6,6 +6,8 @@ import (
 	"advertise_service/internal/handlers"
 	"advertise_service/internal/mock"
 	"advertise_service/internal/models"
+	"advertise_service/internal/ranking"
+	"advertise_service/internal/signer"
 	"bytes"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
@@ -16,16 +18,60 @@ import (
 	"testing"
 )
 
+func endTimeRanker() ranking.Ranker {
+	ranker, _ := ranking.NewRanker(ranking.EndTime)
+	return ranker
+}
+
+func testClickLinks() handlers.ClickLinks {
+	return handlers.ClickLinks{Signer: signer.New([]byte("test"), signer.DefaultTTL)}
+}
+
 func TestGetAds(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
-	server := NewServer(mock.NewStorage(), mock.NewCache(), logger)
+	server := NewServer(mock.NewStorage(), mock.NewCache(), endTimeRanker(), testClickLinks(), logger)
 	requests := generatePostAdsRequests()
 	for _, req := range requests {
 		postAd(t, server, req)
 	}
-	getAds(t, server, "/api/v1/ad?limit=1000&offset=0&age=24&gender=F&country=TW&platform=ios")
+	getAds(t, server, "/api/v1/ad?limit=1000&age=24&gender=F&country=TW&platform=ios")
 	//get second time uses cache, so need additional testing
-	getAds(t, server, "/api/v1/ad?limit=1000&offset=0&age=24&gender=M&country=JP&platform=web")
+	getAds(t, server, "/api/v1/ad?limit=1000&age=24&gender=M&country=JP&platform=web")
+}
+
+func TestAdResource(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	server := NewServer(mock.NewStorage(), mock.NewCache(), endTimeRanker(), testClickLinks(), logger)
+	created := postAd(t, server, generatePostAdsRequests()[0])
+
+	send := func(method string, url string, body string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	response := send(http.MethodGet, "/api/v1/ad/"+created.AdID, "")
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+	response = send(http.MethodPatch, "/api/v1/ad/"+created.AdID, `{"title":"patched"}`)
+	require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	var ad models.Ad
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &ad))
+	assert.Equal(t, "patched", ad.Title)
+
+	//clicks are only recorded through the signed click redirect
+	response = send(http.MethodPost, "/api/v1/ad/"+created.AdID+"/click", "")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	response = send(http.MethodDelete, "/api/v1/ad/"+created.AdID, "")
+	require.Equal(t, http.StatusNoContent, response.Code, response.Body.String())
+
+	response = send(http.MethodGet, "/api/v1/ad/"+created.AdID, "")
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	response = send(http.MethodGet, "/api/v1/ad/not-an-id", "")
+	assert.Equal(t, http.StatusBadRequest, response.Code)
 }
 
 func getAds(t *testing.T, server http.Handler, url string) {
@@ -48,7 +94,7 @@ func getAds(t *testing.T, server http.Handler, url string) {
 	}
 }
 
-func postAd(t *testing.T, server http.Handler, reqBody handlers.PostAdRequest) {
+func postAd(t *testing.T, server http.Handler, reqBody handlers.PostAdRequest) handlers.PostAdResponse {
 	jsonStr, err := json.Marshal(reqBody)
 	require.NoError(t, err)
 	request, err := http.NewRequest(http.MethodPost, "/api/v1/ad", bytes.NewBuffer(jsonStr))
@@ -56,6 +102,9 @@ func postAd(t *testing.T, server http.Handler, reqBody handlers.PostAdRequest) {
 	response := httptest.NewRecorder()
 	server.ServeHTTP(response, request)
 	assert.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+	var created handlers.PostAdResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &created))
+	return created
 }
 
 func generatePostAdsRequests() []handlers.PostAdRequest {
@@ -0,0 +1,86 @@
+package signer
+
+import (
+	"advertise_service/internal/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is how long a click token stays valid after the impression
+const DefaultTTL = 24 * time.Hour
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed or whose signature doesn't match
+	ErrInvalidToken = errors.New("invalid click token")
+	// ErrExpiredToken is returned for tokens with a valid signature that are past their expiry
+	ErrExpiredToken = errors.New("click token expired")
+)
+
+// Click is what a click token carries, the impression it was issued for and the targeting params of the request
+type Click struct {
+	ImpressionID uuid.UUID              `json:"i"`
+	AdID         uuid.UUID              `json:"a"`
+	Params       models.ConditionParams `json:"p"`
+	ExpiresAt    int64                  `json:"e"`
+}
+
+// Signer issues and verifies click tokens with HMAC-SHA256, all replicas must share the key
+type Signer struct {
+	key []byte
+	ttl time.Duration
+}
+
+func New(key []byte, ttl time.Duration) Signer {
+	return Signer{key: key, ttl: ttl}
+}
+
+// Sign returns a url safe token for a new impression of the ad, valid for the ttl of the signer
+func (s Signer) Sign(adID uuid.UUID, params models.ConditionParams, now time.Time) (string, error) {
+	payload, err := json.Marshal(Click{
+		ImpressionID: uuid.New(),
+		AdID:         adID,
+		Params:       params,
+		ExpiresAt:    now.Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+// Verify checks the signature of the token before decoding it, and rejects it once it's expired at now
+func (s Signer) Verify(token string, now time.Time) (Click, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Click{}, ErrInvalidToken
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, s.mac(encoded)) {
+		return Click{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Click{}, ErrInvalidToken
+	}
+	var click Click
+	if err := json.Unmarshal(payload, &click); err != nil {
+		return Click{}, ErrInvalidToken
+	}
+	if now.Unix() >= click.ExpiresAt {
+		return Click{}, ErrExpiredToken
+	}
+	return click, nil
+}
+
+func (s Signer) mac(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,51 @@
+package signer
+
+import (
+	"advertise_service/internal/models"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	signer := New([]byte("secret"), time.Hour)
+	now := time.Now().UTC()
+	adID := uuid.New()
+	params := models.ConditionParams{Age: 24, Gender: models.Female, Country: models.Taiwan, Platform: models.Ios}
+
+	token, err := signer.Sign(adID, params, now)
+	require.NoError(t, err)
+	click, err := signer.Verify(token, now.Add(59*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, adID, click.AdID)
+	assert.Equal(t, params, click.Params)
+	assert.NotEqual(t, uuid.Nil, click.ImpressionID)
+
+	//every impression gets its own token
+	other, err := signer.Sign(adID, params, now)
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	_, err = signer.Verify(token, now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrExpiredToken)
+
+	_, err = New([]byte("other secret"), time.Hour).Verify(token, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	//pointing the token at another ad breaks the signature
+	encoded, signature, _ := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	tampered := strings.Replace(string(payload), adID.String(), uuid.New().String(), 1)
+	_, err = signer.Verify(base64.RawURLEncoding.EncodeToString([]byte(tampered))+"."+signature, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	for _, garbage := range []string{"", "abc", "abc.def", "." + signature} {
+		_, err = signer.Verify(garbage, now)
+		assert.ErrorIs(t, err, ErrInvalidToken, garbage)
+	}
+}
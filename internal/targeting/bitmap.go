@@ -0,0 +1,45 @@
+package targeting
+
+import "math/bits"
+
+// bitmap is a fixed size set of condition positions
+type bitmap []uint64
+
+func newBitmap(size int) bitmap {
+	return make(bitmap, (size+63)/64)
+}
+
+func (b bitmap) set(i int) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitmap) clone() bitmap {
+	c := make(bitmap, len(b))
+	copy(c, b)
+	return c
+}
+
+// or adds all positions of other into b
+func (b bitmap) or(other bitmap) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+// and keeps only the positions of b that are also in other
+func (b bitmap) and(other bitmap) {
+	for i := range b {
+		b[i] &= other[i]
+	}
+}
+
+// forEach calls fn with every position in ascending order
+func (b bitmap) forEach(fn func(i int)) {
+	for word, value := range b {
+		for value != 0 {
+			bit := bits.TrailingZeros64(value)
+			fn(word*64 + bit)
+			value &= value - 1
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package targeting
+
+import (
+	"advertise_service/internal/models"
+	"sync"
+	"time"
+)
+
+// FallbackTTL is how long an index of ads read from the database is reused while the cache is invalid
+const FallbackTTL = 5 * time.Second
+
+// Engine holds the index of the currently cached ads,
+// the index is rebuilt whenever the version of the cache changes.
+type Engine struct {
+	mu      sync.RWMutex
+	index   *Index
+	version int64
+
+	// fallbackMu is held while loading the fallback, so concurrent requests wait for a single load
+	fallbackMu sync.Mutex
+	fallback   *Index
+	fallbackAt time.Time
+}
+
+func NewEngine() *Engine {
+	return &Engine{}
+}
+
+// Get returns the index if it was built from the given cache version
+func (e *Engine) Get(version int64) (*Index, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.index == nil || e.version != version {
+		return nil, false
+	}
+	return e.index, true
+}
+
+// Set replaces the index with one built from the given cache version
+func (e *Engine) Set(version int64, index *Index) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.index = index
+	e.version = version
+}
+
+// Fallback returns an index of the ads returned by load, used while the cache is invalid.
+// Concurrent callers share a single load and its index is reused for FallbackTTL,
+// so an invalid cache doesn't turn every request into a database query.
+func (e *Engine) Fallback(load func() ([]models.Ad, error)) (*Index, error) {
+	e.fallbackMu.Lock()
+	defer e.fallbackMu.Unlock()
+	if e.fallback != nil && time.Since(e.fallbackAt) < FallbackTTL {
+		return e.fallback, nil
+	}
+	ads, err := load()
+	if err != nil {
+		return nil, err
+	}
+	e.fallback = NewIndex(ads)
+	e.fallbackAt = time.Now()
+	return e.fallback, nil
+}
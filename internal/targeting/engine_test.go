@@ -0,0 +1,46 @@
+package targeting
+
+import (
+	"advertise_service/internal/models"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEngineFallback(t *testing.T) {
+	engine := NewEngine()
+	now := time.Now().UTC()
+	var loads atomic.Int32
+	load := func() ([]models.Ad, error) {
+		loads.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return []models.Ad{{ID: uuid.New(), StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}}, nil
+	}
+
+	//concurrent requests share a single database read
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			index, err := engine.Fallback(load)
+			require.NoError(t, err)
+			assert.Equal(t, 1, index.Len())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+
+	//the fallback is read again once it expired, a failed read isn't kept
+	engine.fallbackAt = time.Now().Add(-FallbackTTL)
+	_, err := engine.Fallback(func() ([]models.Ad, error) { return nil, errors.New("database is down") })
+	assert.Error(t, err)
+	_, err = engine.Fallback(load)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), loads.Load())
+}
@@ -0,0 +1,218 @@
+package targeting
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/models"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// MaxIndexedAge is the exclusive upper bound of ages that have a precomputed bitmap,
+// older ages are rare and evaluated against the conditions directly.
+const MaxIndexedAge = 128
+
+// Index is an inverted index over the conditions of a set of ads.
+// Every condition owns a position, and for each targeting value there is a bitmap of the conditions accepting it,
+// so matching a ConditionParams is an intersection of a few bitmaps instead of evaluating every condition.
+// Ads targeted by an expression are evaluated one by one, and merged with the matched conditions in the order of the cache.
+type Index struct {
+	// ads sorted the same way as the cache
+	ads []models.Ad
+	// ids maps the id of every indexed ad to its position in ads
+	ids map[uuid.UUID]int
+	// owners maps a condition position to its ad in ads
+	owners []int
+	// conditions by position
+	conditions []models.Condition
+	// expressions are the ads targeted by an expression, which can't be split into bitmaps and are evaluated directly
+	expressions []int
+
+	genders   dimension[models.Gender]
+	countries dimension[models.Country]
+	platforms dimension[models.Platform]
+	segments  dimension[uuid.UUID]
+	// ages[a] contains the conditions accepting age a
+	ages []bitmap
+	// referencedSegments are the segments targeted by any of the ads, sorted
+	referencedSegments []uuid.UUID
+}
+
+// dimension holds the bitmaps of a single targeting field
+type dimension[T comparable] struct {
+	// values contains the conditions listing the value, plus the conditions that don't restrict the field
+	values map[T]bitmap
+	// unrestricted contains the conditions that don't restrict the field, which is what unknown values match
+	unrestricted bitmap
+}
+
+func newDimension[T comparable](size int) dimension[T] {
+	return dimension[T]{values: map[T]bitmap{}, unrestricted: newBitmap(size)}
+}
+
+func (d dimension[T]) add(position int, size int, listed []T) {
+	if len(listed) == 0 {
+		d.unrestricted.set(position)
+		return
+	}
+	for _, value := range listed {
+		if _, ok := d.values[value]; !ok {
+			d.values[value] = newBitmap(size)
+		}
+		d.values[value].set(position)
+	}
+}
+
+// seal merges the unrestricted conditions into every value
+func (d dimension[T]) seal() {
+	for _, b := range d.values {
+		b.or(d.unrestricted)
+	}
+}
+
+func (d dimension[T]) get(value T) bitmap {
+	if b, ok := d.values[value]; ok {
+		return b
+	}
+	return d.unrestricted
+}
+
+// getAny returns the conditions accepting any of the values, for fields such as segments where the viewer has many values
+func (d dimension[T]) getAny(values []T) bitmap {
+	if len(values) == 0 {
+		return d.unrestricted
+	}
+	b := d.get(values[0]).clone()
+	for _, value := range values[1:] {
+		b.or(d.get(value))
+	}
+	return b
+}
+
+// NewIndex builds an index over the ads, the ads slice isn't modified.
+func NewIndex(ads []models.Ad) *Index {
+	ads = slices.Clone(ads)
+	slices.SortFunc(ads, cache.CompareAds)
+
+	idx := &Index{ads: ads, ids: make(map[uuid.UUID]int, len(ads))}
+	for i, ad := range ads {
+		idx.ids[ad.ID] = i
+		idx.referencedSegments = append(idx.referencedSegments, ad.Segments()...)
+		if ad.Targeting != nil {
+			idx.expressions = append(idx.expressions, i)
+			continue
+		}
+		//an ad without conditions is shown to everyone, which is what an empty condition matches
+		conditions := ad.Conditions
+		if len(conditions) == 0 {
+			conditions = []models.Condition{{}}
+		}
+		for _, condition := range conditions {
+			idx.owners = append(idx.owners, i)
+			idx.conditions = append(idx.conditions, condition)
+		}
+	}
+
+	size := len(idx.conditions)
+	idx.genders = newDimension[models.Gender](size)
+	idx.countries = newDimension[models.Country](size)
+	idx.platforms = newDimension[models.Platform](size)
+	idx.segments = newDimension[uuid.UUID](size)
+	idx.ages = make([]bitmap, MaxIndexedAge)
+	for age := range idx.ages {
+		idx.ages[age] = newBitmap(size)
+	}
+
+	for position, condition := range idx.conditions {
+		idx.genders.add(position, size, condition.Gender)
+		idx.countries.add(position, size, condition.Country)
+		idx.platforms.add(position, size, condition.Platform)
+		idx.segments.add(position, size, condition.Segments)
+		for age := range idx.ages {
+			if condition.MatchAge(age) {
+				idx.ages[age].set(position)
+			}
+		}
+	}
+	idx.genders.seal()
+	idx.countries.seal()
+	idx.platforms.seal()
+	idx.segments.seal()
+	slices.SortFunc(idx.referencedSegments, func(a, b uuid.UUID) int {
+		return slices.Compare(a[:], b[:])
+	})
+	idx.referencedSegments = slices.Compact(idx.referencedSegments)
+	return idx
+}
+
+// Len returns the amount of indexed ads
+func (idx *Index) Len() int {
+	return len(idx.ads)
+}
+
+// Contains reports if the ad is indexed and is being served right now, the same way Match filters the matched ads
+func (idx *Index) Contains(id uuid.UUID) bool {
+	i, ok := idx.ids[id]
+	if !ok {
+		return false
+	}
+	ad := idx.ads[i]
+	return ad.IsEnabled() && ad.IsActiveAt(time.Now().UTC())
+}
+
+// Segments returns the segments targeted by the indexed ads, which are the only memberships Match needs in the params
+func (idx *Index) Segments() []uuid.UUID {
+	return idx.referencedSegments
+}
+
+// Match returns the ads that should be shown for the params, in the same order as the cache.
+// It returns the same ads as filtering with models.Ad.ShouldShow.
+func (idx *Index) Match(params models.ConditionParams) []models.Ad {
+	matched := idx.ageBitmap(params.Age).clone()
+	matched.and(idx.genders.get(params.Gender))
+	matched.and(idx.countries.get(params.Country))
+	matched.and(idx.platforms.get(params.Platform))
+	matched.and(idx.segments.getAny(params.Segments))
+
+	var owners []int
+	last := -1
+	matched.forEach(func(position int) {
+		//conditions of the same ad are next to each other, so checking the last ad is enough to deduplicate
+		owner := idx.owners[position]
+		if owner != last {
+			owners = append(owners, owner)
+			last = owner
+		}
+	})
+	if len(idx.expressions) > 0 {
+		for _, owner := range idx.expressions {
+			if idx.ads[owner].Targeting.Eval(params) {
+				owners = append(owners, owner)
+			}
+		}
+		slices.Sort(owners)
+	}
+
+	ads := make([]models.Ad, 0)
+	now := time.Now().UTC()
+	for _, owner := range owners {
+		ad := idx.ads[owner]
+		if ad.IsEnabled() && ad.IsActiveAt(now) {
+			ads = append(ads, ad)
+		}
+	}
+	return ads
+}
+
+func (idx *Index) ageBitmap(age int) bitmap {
+	if age >= 0 && age < MaxIndexedAge {
+		return idx.ages[age]
+	}
+	b := newBitmap(len(idx.conditions))
+	for position, condition := range idx.conditions {
+		if condition.MatchAge(age) {
+			b.set(position)
+		}
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package targeting
+
+import (
+	"advertise_service/internal/infra/cache"
+	"advertise_service/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	genders   = []models.Gender{models.Male, models.Female}
+	countries = []models.Country{models.Taiwan, models.Japan}
+	platforms = []models.Platform{models.Android, models.Ios, models.Web}
+	segments  = []uuid.UUID{uuid.MustParse("8d1cbd1c-5b6a-4c3e-9a53-2a8d7b0e6f01"), uuid.MustParse("3f2a9e47-0c1d-4b8e-8f6a-9d5c1e2b7a02")}
+)
+
+func pick[T any](r *rand.Rand, values []T) []T {
+	var picked []T
+	for _, value := range values {
+		if r.Intn(2) == 0 {
+			picked = append(picked, value)
+		}
+	}
+	return picked
+}
+
+func generateAds(r *rand.Rand, amount int) []models.Ad {
+	now := time.Now().UTC()
+	ads := make([]models.Ad, amount)
+	for i := range ads {
+		ad := models.Ad{
+			ID:      uuid.New(),
+			Title:   "ad",
+			StartAt: now.Add(-time.Hour),
+			EndAt:   now.Add(time.Duration(1+r.Intn(24*60)) * time.Minute),
+		}
+		//some ads haven't started or are paused
+		switch r.Intn(20) {
+		case 0:
+			ad.StartAt = now.Add(time.Hour)
+		case 1:
+			ad.Status = models.StatusPaused
+		}
+		for range r.Intn(3) {
+			condition := models.Condition{
+				Gender:   pick(r, genders),
+				Country:  pick(r, countries),
+				Platform: pick(r, platforms),
+			}
+			if r.Intn(2) == 0 {
+				condition.AgeStart = r.Intn(60)
+				condition.AgeEnd = condition.AgeStart + r.Intn(40)
+			}
+			if r.Intn(4) == 0 {
+				condition.Segments = pick(r, segments)
+			}
+			ad.Conditions = append(ad.Conditions, condition)
+		}
+		//some ads are targeted by an expression instead, which is evaluated outside of the bitmaps
+		if r.Intn(4) == 0 {
+			excluded := models.Expression{Field: models.FieldCountry, Op: models.OpIn, Values: []string{string(countries[r.Intn(len(countries))])}}
+			ad.Targeting = &models.Expression{And: []models.Expression{
+				{Not: &excluded},
+				{Field: models.FieldPlatform, Op: models.OpIn, Values: []string{string(platforms[r.Intn(len(platforms))])}},
+			}}
+		}
+		ads[i] = ad
+	}
+	return ads
+}
+
+func generateParams(r *rand.Rand) models.ConditionParams {
+	return models.ConditionParams{
+		Age:      r.Intn(MaxIndexedAge + 20),
+		Gender:   genders[r.Intn(len(genders))],
+		Country:  countries[r.Intn(len(countries))],
+		Platform: platforms[r.Intn(len(platforms))],
+		Segments: pick(r, segments),
+	}
+}
+
+// linearScan is the reference implementation the index has to agree with
+func linearScan(ads []models.Ad, params models.ConditionParams) []models.Ad {
+	matched := make([]models.Ad, 0)
+	for _, ad := range ads {
+		if ad.ShouldShow(params) {
+			matched = append(matched, ad)
+		}
+	}
+	return matched
+}
+
+func TestIndexMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ads := generateAds(r, 1000)
+	index := NewIndex(ads)
+	require.Equal(t, len(ads), index.Len())
+
+	slices.SortFunc(ads, cache.CompareAds)
+	for range 500 {
+		params := generateParams(r)
+		assert.Equal(t, linearScan(ads, params), index.Match(params), params.String())
+	}
+
+	//values that no ad targets only match unrestricted conditions
+	params := models.ConditionParams{Age: 30, Gender: models.Male, Country: "US", Platform: models.Web}
+	assert.Equal(t, linearScan(ads, params), index.Match(params))
+	//every targeted segment is listed once, sorted
+	assert.Equal(t, []uuid.UUID{segments[1], segments[0]}, index.Segments())
+}
+
+func TestEmptyIndex(t *testing.T) {
+	index := NewIndex(nil)
+	assert.Empty(t, index.Match(models.ConditionParams{Age: 20}))
+}
+
+func BenchmarkIndexMatch(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	index := NewIndex(generateAds(r, 1000))
+	params := generateParams(r)
+	b.ResetTimer()
+	for range b.N {
+		index.Match(params)
+	}
+}
+
+func BenchmarkLinearScan(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	ads := generateAds(r, 1000)
+	slices.SortFunc(ads, cache.CompareAds)
+	params := generateParams(r)
+	b.ResetTimer()
+	for range b.N {
+		linearScan(ads, params)
+	}
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	ads := generateAds(r, 1000)
+	b.ResetTimer()
+	for range b.N {
+		NewIndex(ads)
+	}
+}